@@ -7,40 +7,291 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"ops-ctrl/pkg/events"
 	"ops-ctrl/pkg/service"
 )
 
 func sendRequest(request map[string]interface{}) {
+	response := receive(request)
+	fmt.Printf("Response:%s\n", response["message"])
+	printDetails(response)
+}
+
+// receive sends the request and returns the response of the daemon
+func receive(request map[string]interface{}) map[string]interface{} {
+	conn := connect(request)
+	defer conn.Close()
+
+	var response map[string]interface{}
+	decoder := json.NewDecoder(conn)
+	err := decoder.Decode(&response)
+	if err != nil {
+		log.Fatalf("Failed to decode response: %v", err)
+	}
+	return response
+}
+
+// listServices prints the services as a table
+func listServices() {
+	response := receive(map[string]interface{}{"action": "list"})
+	services, _ := response["services"].([]interface{})
+	fmt.Printf("%-24s %-12s %-8s %-10s %s\n", "ID", "STATE", "PID", "HEALTH", "RESTARTS")
+	for _, item := range services {
+		service, _ := item.(map[string]interface{})
+		pid := "-"
+		if value, ok := service["pid"].(float64); ok {
+			pid = strconv.Itoa(int(value))
+		}
+		fmt.Printf("%-24v %-12v %-8s %-10v %v\n", service["id"], service["state"], pid, service["health"], service["restarts"])
+	}
+}
+
+// listMounts prints the fstab and configured mounts as a table
+func listMounts() {
+	response := receive(map[string]interface{}{"action": "mounts"})
+	list, _ := response["mounts"].([]interface{})
+	fmt.Printf("%-24s %-24s %-10s %-10s %s\n", "TARGET", "SOURCE", "TYPE", "STATE", "DETAIL")
+	for _, item := range list {
+		mount, _ := item.(map[string]interface{})
+		detail, _ := mount["error"].(string)
+		if external, _ := mount["external"].(bool); external && detail == "" {
+			detail = "mounted externally"
+		} else if device, _ := mount["device"].(string); device != "" && detail == "" {
+			detail = "on " + device
+		}
+		fmt.Printf("%-24v %-24v %-10v %-10v %s\n", mount["target"], mount["source"], mount["fstype"], mount["state"], detail)
+	}
+}
+
+// connect sends the request to the daemon
+func connect(request map[string]interface{}) net.Conn {
 	conn, err := net.Dial("unix", "/tmp/ops-ctrl-daemon.sock")
 	if err != nil {
 		log.Fatal("Failed to connect to daemon:", err)
 	}
-	defer conn.Close()
 
 	encoder := json.NewEncoder(conn)
 	err = encoder.Encode(request)
 	if err != nil {
 		log.Fatalf("Failed to send request: %v", err)
 	}
+	return conn
+}
+
+// watchEvents prints events until the daemon closes the connection. Arguments
+// are service IDs to watch, "--since <seq>" to resume and "--json" to print
+// the raw JSON lines.
+func watchEvents(arguments []string) {
+	request := map[string]interface{}{"action": "watch"}
+	services := []string{}
+	rawJSON := false
+	for i := 0; i < len(arguments); i++ {
+		switch arguments[i] {
+		case "--since":
+			if i+1 >= len(arguments) {
+				log.Fatal("Missing value for --since")
+			}
+			since, err := strconv.ParseUint(arguments[i+1], 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid sequence number: %s", arguments[i+1])
+			}
+			request["since"] = since
+			i++
+		case "--json":
+			rawJSON = true
+		default:
+			services = append(services, arguments[i])
+		}
+	}
+	request["services"] = services
+
+	conn := connect(request)
+	defer conn.Close()
 
-	var response map[string]interface{}
 	decoder := json.NewDecoder(conn)
-	err = decoder.Decode(&response)
-	if err != nil {
-		log.Fatalf("Failed to decode response: %v", err)
+	for {
+		var event events.Event
+		if err := decoder.Decode(&event); err != nil {
+			fmt.Println("Event stream closed, resume with --since")
+			return
+		}
+		if rawJSON {
+			line, _ := json.Marshal(event)
+			fmt.Println(string(line))
+			continue
+		}
+		fmt.Printf("%d %s %-15s %s", event.Seq, event.Time.Format("15:04:05.000"), event.Kind, event.Service)
+		if event.PID != 0 {
+			fmt.Printf(" pid:%d", event.PID)
+		}
+		if event.Message != "" {
+			fmt.Printf(" %s", event.Message)
+		}
+		fmt.Println()
 	}
+}
 
-	fmt.Printf("Response:%s\n", response["message"])
+// queryAudit prints the audit records matching "--since", "--until", "-u"
+// (user), "-i" (service) and "--limit", "--json" prints the raw JSON lines
+func queryAudit(arguments []string) {
+	request := map[string]interface{}{"action": "audit"}
+	options := map[string]string{
+		"--since":   "since",
+		"--until":   "until",
+		"-u":        "user",
+		"--user":    "user",
+		"-i":        "service",
+		"--service": "service",
+		"--limit":   "limit",
+	}
+	rawJSON := false
+	for i := 0; i < len(arguments); i++ {
+		if arguments[i] == "--json" {
+			rawJSON = true
+			continue
+		}
+		key, known := options[arguments[i]]
+		if !known {
+			log.Fatalf("Unknown audit option: %s", arguments[i])
+		}
+		if i+1 >= len(arguments) {
+			log.Fatalf("Missing value for %s", arguments[i])
+		}
+		request[key] = arguments[i+1]
+		if key == "limit" {
+			limit, err := strconv.Atoi(arguments[i+1])
+			if err != nil {
+				log.Fatalf("Invalid limit: %s", arguments[i+1])
+			}
+			request[key] = limit
+		}
+		i++
+	}
+
+	response := receive(request)
+	if response["status"] != "success" {
+		fmt.Printf("Response:%s\n", response["message"])
+		os.Exit(1)
+	}
+	records, _ := response["records"].([]interface{})
+	for _, item := range records {
+		if rawJSON {
+			line, _ := json.Marshal(item)
+			fmt.Println(string(line))
+			continue
+		}
+		record, _ := item.(map[string]interface{})
+		peer, _ := record["peer"].(map[string]interface{})
+		when, _ := time.Parse(time.RFC3339Nano, fmt.Sprint(record["time"]))
+		who := fmt.Sprintf("uid=%v pid=%v %v", peer["uid"], peer["pid"], peer["exe"])
+		if peer["source"] == "api" {
+			who = fmt.Sprintf("api %v %v", peer["remote"], peer["token"])
+		}
+		target, _ := record["service"].(string)
+		if target == "" {
+			target = "-"
+		}
+		message, _ := record["message"].(string)
+		fmt.Printf("%s %s %-13v %-20s %v: %s\n", when.Local().Format("2006-01-02 15:04:05"), who, record["action"], target, record["result"], strings.TrimSpace(message))
+	}
+}
+
+// printDetails prints the fields of a response other than status and message
+func printDetails(response map[string]interface{}) {
+	keys := []string{}
+	for key := range response {
+		if key != "status" && key != "message" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch value := response[key].(type) {
+		case map[string]interface{}:
+			if key == "usage" {
+				printUsage(value)
+				continue
+			}
+			fmt.Printf("%s:\n", key)
+			names := []string{}
+			for name := range value {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Printf("  %-12s %v\n", name, value[name])
+			}
+		case []interface{}:
+			fmt.Printf("%s:\n", key)
+			for _, item := range value {
+				fmt.Printf("  %v\n", item)
+			}
+		default:
+			fmt.Printf("%s: %v\n", key, value)
+		}
+	}
+}
+
+// printUsage prints the resource usage of a status response like a top
+// snapshot
+func printUsage(usage map[string]interface{}) {
+	number := func(key string) float64 {
+		value, _ := usage[key].(float64)
+		return value
+	}
+
+	pids := []string{}
+	if values, ok := usage["pids"].([]interface{}); ok {
+		for _, pid := range values {
+			pids = append(pids, fmt.Sprintf("%v", pid))
+		}
+	}
+	fmt.Println("usage:")
+	fmt.Printf("  %-10s %s\n", "PIDs", strings.Join(pids, " "))
+	if started, err := time.Parse(time.RFC3339Nano, fmt.Sprint(usage["start_time"])); err == nil && !started.IsZero() {
+		fmt.Printf("  %-10s %s (up %v)\n", "Started", started.Local().Format("2006-01-02 15:04:05"), time.Since(started).Round(time.Second))
+	}
+	fmt.Printf("  %-10s %.1f%%, %.2fs total\n", "CPU", number("cpu_percent"), number("cpu_seconds"))
+	fmt.Printf("  %-10s RSS %s, PSS %s\n", "Memory", formatBytes(number("rss_bytes")), formatBytes(number("pss_bytes")))
+	fmt.Printf("  %-10s %.0f\n", "Threads", number("threads"))
+	fmt.Printf("  %-10s %.0f\n", "Open FDs", number("open_fds"))
+	fmt.Printf("  %-10s read %s, written %s\n", "I/O", formatBytes(number("read_bytes")), formatBytes(number("write_bytes")))
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", bytes, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[unit])
 }
 
 func addArguments(arguments map[service.Argument]interface{}, request map[string]interface{}) {
 	for key, value := range arguments {
 		if key.SupportsArrays() {
 			// TODO add multiple arguments with other values than "string".
-			itemValues := strings.Split(value.(string), ",")
+			itemValues := value.([]string)
+			// Program arguments can also be given as a comma separated list.
+			// Environment variables are passed whole since values can contain
+			// commas, "-e" is repeated for several variables.
+			if key == service.ProgramArguments {
+				itemValues = []string{}
+				for _, item := range value.([]string) {
+					itemValues = append(itemValues, strings.Split(item, ",")...)
+				}
+			}
 			for i, item := range itemValues {
 				fmt.Printf("%s [%d] %s\n", key, i, item)
 			}
@@ -94,6 +345,50 @@ func main() {
 
 		addArguments(validArgs, request)
 		sendRequest(request)
+	case "stop", "restart", "try-restart", "remove", "reload":
+		validArgs := service.CheckArguments(argumentsAfterAction)
+		request := map[string]interface{}{"action": first_argument}
+
+		addArguments(validArgs, request)
+		sendRequest(request)
+	case "list":
+		listServices()
+	case "logs":
+		validArgs := service.CheckArguments(argumentsAfterAction)
+		request := map[string]interface{}{"action": "logs"}
+
+		addArguments(validArgs, request)
+		response := receive(request)
+		if response["status"] != "success" {
+			fmt.Printf("Response:%s\n", response["message"])
+			os.Exit(1)
+		}
+		fmt.Print(response["output"])
+	case "watch":
+		watchEvents(argumentsAfterAction)
+	case "audit":
+		queryAudit(argumentsAfterAction)
+	case "boot-log":
+		response := receive(map[string]interface{}{"action": "boot-log"})
+		fmt.Printf("Response:%s\n", response["message"])
+		fmt.Print(response["log"])
+	case "mounts":
+		listMounts()
+	case "daemon-reload":
+		sendRequest(map[string]interface{}{"action": "daemon-reload"})
+	case "daemon-reexec":
+		validArgs := service.CheckArguments(argumentsAfterAction)
+		request := map[string]interface{}{"action": "daemon-reexec"}
+
+		addArguments(validArgs, request)
+		sendRequest(request)
+	case "isolate":
+		if len(argumentsAfterAction) == 0 {
+			fmt.Println("Missing target")
+			os.Exit(1)
+		}
+		request := map[string]interface{}{"action": "isolate", "target": argumentsAfterAction[0]}
+		sendRequest(request)
 	case "poweroff":
 		err := exec.Command("poweroff").Run()
 		if err != nil {
@@ -108,18 +403,97 @@ Action: Start process
 start -b /usr/bin/chromium
 start -i uniqueName -b /usr/bin/firefox -arg google.com
 start -a firefox
+start -t forking --pid_file /run/sshd.pid -b /usr/sbin/sshd
+start -t notify -a mydaemon
+start -a worker@orders
+start -b /usr/bin/firefox -l /tmp/firefox.log
+start -b /usr/bin/app -e 'LIST=a,b' -e 'URL=${HOST}/api' --env_file /etc/app.env
+start -b nginx -u www-data -g www-data -w /var/www
+start -i uniqueName -b /usr/bin/firefox --replace
+
+"-e" sets one variable and is repeated for several, values keep their commas
+like in "-e 'LIST=a,b'". "status" shows the environment of the last start, values
+read from an env_file are masked.
+
+IDs must be unique, "--replace" stops and replaces a service with the same ID.
+Without "-i" the ID is derived from the alias or binary name, like "firefox-2".
+Other actions accept a unique ID prefix or alias as "-i".
+
+The binary, working directory and user are checked before the service is
+registered, binaries without a "/" are looked up in PATH.
+
+Service types ("-t", "--type"):
+simple   started once spawned (default)
+exec     started once the binary was executed
+forking  started when the parent exits, main PID read from "--pid_file"
+notify   started when the service sends READY=1 to $NOTIFY_SOCKET
+
+Templates: a service definition named "worker@" is started as "worker@<instance>".
+Its args, env, working_dir and log_path may use %i (instance), %n (full name),
+%h (home directory) and %% (literal %). Instances get their name as ID.
 
 Action: Send signal
 (Depends: signal and -p or -i)
 signal SIGTERM -p 123150
 signal SIGKILL -i uniqueName
 
-Action: Check process status
+Action: Stop, restart, reload or remove a service
+(Depends: -p or -i, optional -T timeout before SIGKILL, default stop_timeout)
+stop -i uniqueName
+stop -i uniqueName -T 30s
+restart -i uniqueName
+try-restart -p 321312
+remove -i uniqueName
+reload -i uniqueName      (runs the exec_reload hooks)
+
+Action: List services
+list
+
+Action: Print the captured output of a service
 (Depends: -p or -i)
+logs -i uniqueName
+
+Action: Check process status
+(Depends: -p or -i, optional --tree to include the processes started by the service)
 status -p 321312
 status -i uniqueName
+status -i uniqueName --tree
+
+The status shows CPU time and percentage, RSS and PSS memory, threads, open
+files, storage I/O and the start time of a running service, read from /proc.
+
+Action: Switch target
+(Depends: target name)
+isolate rescue
+
+Action: Watch service events (started, ready, exited, failed, restarting, ...)
+(Optional: service IDs, --since <seq> to resume, --json for JSON lines)
+watch
+watch uniqueName worker@orders --since 42 --json
+
+Action: Query the audit log of the control requests
+(Optional: --since and --until as RFC 3339, 2006-01-02 or a duration like 2h,
+-u user name or UID, -i service, --limit newest N records, --json)
+audit --since 1h
+audit -u alice -i uniqueName --limit 20
+
+Action: Show the boot sequence of PID 1 (mounts, hostname, loopback)
+boot-log
+
+Action: List the mounts of fstab and [mounts] with their state
+Services mount what they need first with requires_mounts_for.
+mounts
+
+Action: Reload config.toml
+daemon-reload
+
+Action: Execute the daemon binary again, for example after an upgrade
+(Optional: -b new binary, default the running one)
+Running services keep running and their output keeps being captured.
+daemon-reexec
+daemon-reexec -b /usr/local/bin/ops-ctrl-daemon
 
-Autostart and aliases ("-a", "--alias") are found "config.toml"
+Autostart, aliases, service definitions and targets ("-a", "--alias") are found "config.toml"
 `)
 		os.Exit(0)
 	}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"ops-ctrl/pkg/service"
+)
+
+func TestAddArgumentsKeepsEnvironmentCommas(t *testing.T) {
+	arguments := map[service.Argument]interface{}{
+		service.Envs:             []string{"LIST=a,b", "URL=http://h/?a=1,b=2"},
+		service.ProgramArguments: []string{"-v,--once"},
+	}
+	request := map[string]interface{}{}
+	addArguments(arguments, request)
+
+	if got, want := request["env"], []string{"LIST=a,b", "URL=http://h/?a=1,b=2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+	if got, want := request["program_argument"], []string{"-v", "--once"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("program_argument = %q, want %q", got, want)
+	}
+}
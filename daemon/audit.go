@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"ops-ctrl/pkg/audit"
+	"ops-ctrl/pkg/manager"
+)
+
+// recordAudit appends a handled request to the audit log
+func recordAudit(peer audit.Peer, action string, request map[string]interface{}, response map[string]interface{}) {
+	if auditLog == nil {
+		return
+	}
+	params := make(map[string]interface{})
+	for key, value := range request {
+		switch key {
+		case "action":
+		case "env":
+			params[key] = environmentNames(value)
+		case "program_argument":
+			params[key] = redactArguments(value)
+		default:
+			params[key] = value
+		}
+	}
+	result, _ := response["status"].(string)
+	message, _ := response["message"].(string)
+
+	record := audit.Record{
+		Time:    time.Now(),
+		Peer:    peer,
+		Action:  action,
+		Service: auditTarget(request),
+		Params:  params,
+		Result:  result,
+		Message: message,
+	}
+	if err := auditLog.Write(record); err != nil {
+		fmt.Printf("Audit: %v\n", err)
+	}
+}
+
+// environmentNames keeps the names of "NAME=value" entries, the values may
+// be secrets
+func environmentNames(env interface{}) []string {
+	entries, _ := env.([]interface{})
+	texts := []string{}
+	for _, entry := range entries {
+		if text, ok := entry.(string); ok {
+			texts = append(texts, text)
+		}
+	}
+	return variableNames(texts)
+}
+
+// redactArguments keeps how many arguments there were, they may carry
+// secrets like passwords as well
+func redactArguments(args interface{}) []string {
+	entries, _ := args.([]interface{})
+	redacted := make([]string, len(entries))
+	for i := range redacted {
+		redacted[i] = "redacted"
+	}
+	return redacted
+}
+
+// variableNames returns the names of "NAME=value" entries
+func variableNames(entries []string) []string {
+	names := []string{}
+	for _, entry := range entries {
+		name, _, _ := strings.Cut(entry, "=")
+		names = append(names, name)
+	}
+	return names
+}
+
+// auditTarget returns the service a request is about
+func auditTarget(request map[string]interface{}) string {
+	if id := argumentValue(request, "id", ""); id != "" {
+		if resolved, err := mgr.ResolveID(id); err == nil {
+			return resolved
+		}
+		return id
+	}
+	if pid, pidExists := request["pid"].(float64); pidExists {
+		return mgr.GetID(int(pid))
+	}
+	if alias := argumentValue(request, "alias", ""); alias != "" {
+		return alias
+	}
+	if binary := argumentValue(request, "binary", ""); binary != "" && request["action"] == "start" {
+		return manager.NameFromBinary(binary)
+	}
+	return argumentValue(request, "target", "")
+}
+
+// queryAudit returns the records matching "since", "until", "user",
+// "service" and "limit" of the request
+func queryAudit(request map[string]interface{}) ([]audit.Record, error) {
+	if auditLog == nil {
+		return nil, fmt.Errorf("audit log is not available")
+	}
+
+	var filter audit.Filter
+	var err error
+	if filter.Since, err = parseAuditTime(argumentValue(request, "since", "")); err != nil {
+		return nil, err
+	}
+	if filter.Until, err = parseAuditTime(argumentValue(request, "until", "")); err != nil {
+		return nil, err
+	}
+	if name := argumentValue(request, "user", ""); name != "" {
+		uid, err := strconv.Atoi(name)
+		if err != nil {
+			account, err := user.Lookup(name)
+			if err != nil {
+				return nil, fmt.Errorf("unknown user: %s", name)
+			}
+			uid, _ = strconv.Atoi(account.Uid)
+		}
+		filter.UID = &uid
+	}
+	filter.Service = argumentValue(request, "service", "")
+	filter.Limit = int(argumentValue(request, "limit", float64(0)))
+	return auditLog.Query(filter)
+}
+
+// parseAuditTime accepts RFC 3339, "2006-01-02 15:04:05", "2006-01-02" in
+// local time or a duration like "2h" meaning that long ago
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339, 2006-01-02 or a duration like 2h", value)
+}
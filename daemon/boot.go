@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"ops-ctrl/pkg/boot"
+	"ops-ctrl/pkg/config"
+)
+
+// bootLog holds the steps of the boot sequence, empty if it didn't run
+var bootLog []boot.Step
+
+// runBoot performs the boot sequence as PID 1 or when [boot] enables it and
+// prints every step
+func runBoot(settings config.Boot) {
+	enabled := os.Getpid() == 1
+	if settings.Enabled != nil {
+		enabled = *settings.Enabled
+	}
+	if !enabled {
+		return
+	}
+
+	options := boot.Options{Hostname: settings.Hostname, Skip: settings.Skip, Mounts: mountTable}
+	if err := options.Validate(); err != nil {
+		// A typo must not keep PID 1 from booting, every step runs instead
+		fmt.Printf("Boot: %v, running every step\n", err)
+		options.Skip = nil
+	}
+	bootLog = boot.Run(options)
+	fmt.Print("Boot sequence:\n", boot.Format(bootLog))
+
+	if os.Getpid() == 1 {
+		// Ctrl-Alt-Del sends SIGINT to PID 1 instead of restarting at once,
+		// shutdown stops the services first
+		if err := syscall.Reboot(syscall.LINUX_REBOOT_CMD_CAD_OFF); err != nil {
+			fmt.Printf("Boot: failed to hand Ctrl-Alt-Del to the daemon: %v\n", err)
+		}
+	}
+}
+
+// booted reports if this daemon ran the boot sequence. It then owns the
+// system and stops the services at shutdown, otherwise they keep running for
+// the next daemon to adopt.
+func booted() bool {
+	return len(bootLog) > 0
+}
@@ -2,21 +2,37 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"syscall"
+	"time"
 
+	"ops-ctrl/pkg/api"
+	"ops-ctrl/pkg/audit"
+	"ops-ctrl/pkg/boot"
 	"ops-ctrl/pkg/config"
+	"ops-ctrl/pkg/events"
 	"ops-ctrl/pkg/manager"
+	"ops-ctrl/pkg/metrics"
+	"ops-ctrl/pkg/mounts"
 	"ops-ctrl/pkg/service"
 )
 
 var mgr = manager.NewManager()
 
+// control is the listening control socket, handed over by daemon-reexec
+var control net.Listener
+
+// auditLog records every request, nil if it could not be opened
+var auditLog *audit.Log
+
 func verifyAction(err error, message string) map[string]interface{} {
 	if err != nil {
 		return map[string]interface{}{"status": "error", "message": err.Error()}
@@ -25,7 +41,9 @@ func verifyAction(err error, message string) map[string]interface{} {
 	}
 }
 
-func argumentArrayValue[T any](request map[string]interface{}, argumentType string) []T {
+// argumentArrayValue returns the list argument of a request, an error if one
+// of its items has the wrong type
+func argumentArrayValue[T any](request map[string]interface{}, argumentType string) ([]T, error) {
 	item, itemOk := request[argumentType].([]interface{})
 	itemValues := []T{}
 
@@ -37,7 +55,7 @@ func argumentArrayValue[T any](request map[string]interface{}, argumentType stri
 			if envStr, ok := value.(T); ok {
 				newItemValues[index] = envStr
 			} else {
-				log.Fatalf("Warning: Unexpected type in env slice: %s", value)
+				return nil, fmt.Errorf("unexpected type in %s: %v", argumentType, value)
 			}
 		}
 		itemValues = newItemValues
@@ -46,7 +64,7 @@ func argumentArrayValue[T any](request map[string]interface{}, argumentType stri
 			fmt.Printf("%s [%d]: %v\n", argumentType, i, e)
 		}
 	}
-	return itemValues
+	return itemValues, nil
 }
 
 func argumentValue[T any](request map[string]interface{}, argumentType string, defaultValue T) T {
@@ -62,6 +80,106 @@ func argumentValue[T any](request map[string]interface{}, argumentType string, d
 	return defaultValue
 }
 
+// requestedID finds the service of a request by "id", which can also be a
+// unique ID prefix or alias, or by "pid"
+func requestedID(request map[string]interface{}) (string, error) {
+	if id, idExists := request["id"].(string); idExists && id != "" {
+		return mgr.ResolveID(id)
+	}
+	if pid, pidExists := request["pid"].(float64); pidExists {
+		if id := mgr.GetID(int(pid)); id != "" {
+			return id, nil
+		}
+		return "", fmt.Errorf("no service with PID %d", int(pid))
+	}
+	return "", fmt.Errorf("no method for finding program found, use -i or -p")
+}
+
+// requestedTimeout parses the optional "timeout" of a request, either a
+// duration like "5s" or a number of seconds. Zero means the service default.
+func requestedTimeout(request map[string]interface{}) (time.Duration, error) {
+	value := argumentValue(request, "timeout", "")
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout: %s", value)
+	}
+	return timeout, nil
+}
+
+// mergeOptions applies the values given in a request on top of a service
+// definition from the config
+func mergeOptions(definition service.Options, request service.Options) service.Options {
+	if len(request.Args) > 0 {
+		definition.Args = request.Args
+	}
+	definition.Env = append(definition.Env, request.Env...)
+	definition.EnvFile = append(definition.EnvFile, request.EnvFile...)
+	if request.WorkingDir != "" {
+		definition.WorkingDir = request.WorkingDir
+	}
+	if request.Type != "" {
+		definition.Type = request.Type
+	}
+	if request.PIDFile != "" {
+		definition.PIDFile = request.PIDFile
+	}
+	if request.LogPath != "" {
+		definition.LogPath = request.LogPath
+	}
+	if request.User != "" {
+		definition.User = request.User
+	}
+	if request.Group != "" {
+		definition.Group = request.Group
+	}
+	return definition
+}
+
+// watchEvents writes the buffered events after "since" and then every new
+// event as a JSON line, optionally only for the IDs in "services"
+func watchEvents(conn net.Conn, request map[string]interface{}) {
+	since := uint64(argumentValue(request, "since", float64(0)))
+	// The services were already checked by handleRequest
+	services, _ := argumentArrayValue[string](request, "services")
+
+	subscription, backlog := mgr.Events().Subscribe(since, services)
+	defer subscription.Close()
+
+	// The client doesn't send anything else, reading only ends when it disconnects
+	disconnected := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(disconnected)
+	}()
+
+	encoder := json.NewEncoder(conn)
+	for _, event := range backlog {
+		if err := encoder.Encode(event); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case event, ok := <-subscription.C:
+			if !ok {
+				// Dropped for being too slow, the client resumes with "since"
+				return
+			}
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+		case <-disconnected:
+			return
+		}
+	}
+}
+
 func handleConnection(conn net.Conn) {
 	defer conn.Close()
 
@@ -69,53 +187,125 @@ func handleConnection(conn net.Conn) {
 	decoder := json.NewDecoder(conn)
 	err := decoder.Decode(&request)
 	if err != nil {
-		log.Fatalln("Failed to decode request:", err)
+		fmt.Println("Failed to decode request:", err)
 		return
 	}
 	fmt.Println(request)
 
+	response := handleRequest(audit.SocketPeer(conn), request)
+
+	// Watching streams events until the client disconnects, the response is
+	// only sent if the request was refused
+	if request["action"] == "watch" && response["status"] == "success" {
+		watchEvents(conn, request)
+		return
+	}
+
+	encoder := json.NewEncoder(conn)
+	err = encoder.Encode(response)
+	if err != nil {
+		fmt.Println("Failed to encode response:", err)
+		return
+	}
+
+	if binary, reexecRequested := response["binary"].(string); reexecRequested && request["action"] == "daemon-reexec" && response["status"] == "success" {
+		conn.Close()
+		if err := reexec(binary); err != nil {
+			fmt.Printf("Re-exec failed, continuing with the running daemon: %v\n", err)
+		}
+	}
+}
+
+// handleRequest performs the action of a request from the control socket or
+// the HTTP API and returns the response
+func handleRequest(peer audit.Peer, request map[string]interface{}) map[string]interface{} {
+	received := time.Now()
+
 	// The first argument, "start", "stop", etc.
-	action := request["action"].(string)
+	action := argumentValue(request, "action", "")
 
 	// Environment variables
-	envStrings := argumentArrayValue[string](request, "env")
+	envStrings, envErr := argumentArrayValue[string](request, "env")
+
+	// dotenv files with more environment variables
+	envFiles, envFileErr := argumentArrayValue[string](request, "env_file")
 
 	// Arguments for the program binary
-	argStrings := argumentArrayValue[string](request, "program_argument")
+	argStrings, argErr := argumentArrayValue[string](request, "program_argument")
 
-	workingDir := argumentValue(request, "working_dir", "/")
+	workingDir := argumentValue(request, "working_dir", "")
 	var response = make(map[string]interface{})
 
 	switch action {
 	case "start":
-		id := argumentValue(request, "id", mgr.RandomID(10))
-
-		binary := argumentValue(request, "binary", "")
-		if binary != "" {
-			mgr.AddService(id, binary, argStrings, envStrings, workingDir)
+		if err := errors.Join(envErr, envFileErr, argErr); err != nil {
+			response = verifyAction(err, "")
+			break
+		}
+		id := argumentValue(request, "id", "")
+		opts := service.Options{
+			Args:       argStrings,
+			Env:        envStrings,
+			EnvFile:    envFiles,
+			WorkingDir: workingDir,
+			Type:       service.ServiceType(argumentValue(request, "type", "")),
+			PIDFile:    argumentValue(request, "pid_file", ""),
+			LogPath:    argumentValue(request, "log_path", ""),
+			User:       argumentValue(request, "user", ""),
+			Group:      argumentValue(request, "group", ""),
 		}
 
+		binary := argumentValue(request, "binary", "")
 		alias, aliasExists := request["alias"].(string)
 
 		if aliasExists {
 			cfg := config.GetConfig()
+			opts.Alias = alias
 
-			if familiarAlias, familiarAliasesExist := cfg.Aliases[alias]; familiarAliasesExist {
+			if definition, definitionExists := cfg.ServiceDefinition(alias); definitionExists {
+				fmt.Printf("Found service definition:->%s", alias)
+				opts = mergeOptions(definition, opts)
+			} else if familiarAlias, familiarAliasesExist := cfg.Aliases[alias]; familiarAliasesExist {
 				fmt.Printf("Found defined alias:->%s", familiarAlias)
-				mgr.AddService(id, alias, argStrings, envStrings, workingDir)
+				opts.Binary = familiarAlias
 			} else {
-				log.Fatalln("Aliases not found for:", alias)
-				return
+				response = verifyAction(fmt.Errorf("alias not found: %s", alias), "")
+				break
 			}
 		}
 
-		if binary == "" && !aliasExists {
-			log.Fatal("Program binary undefined!")
-			return
+		if binary != "" {
+			opts.Binary = binary
+		}
+
+		if opts.Binary == "" {
+			response = verifyAction(fmt.Errorf("program binary undefined, use -b or -a"), "")
+			break
+		}
+
+		// Template instances are named after the instance
+		if _, _, isInstance := service.SplitInstance(alias); aliasExists && isInstance && id == "" {
+			id = alias
+		}
+
+		var err error
+		switch {
+		case id != "" && argumentValue(request, "replace", false):
+			err = mgr.ReplaceService(id, opts, 0)
+		case id != "":
+			err = mgr.AddService(id, opts)
+		case aliasExists:
+			id, err = mgr.AddServiceWithGeneratedID(alias, opts)
+		default:
+			id, err = mgr.AddServiceWithGeneratedID(manager.NameFromBinary(opts.Binary), opts)
+		}
+		if err != nil {
+			response = verifyAction(err, "")
+			break
 		}
 
 		// Start service
-		err := mgr.StartService(id)
+		err = mgr.StartService(id)
 		pid := strconv.Itoa(mgr.GetPID(id))
 		response = verifyAction(err, "Service "+id+" started with pid: "+pid+"\n")
 
@@ -123,86 +313,328 @@ func handleConnection(conn net.Conn) {
 	case "signal":
 		signalType := argumentValue(request, "signalType", "")
 		if signalType == "" {
-			log.Fatal("Missing signal type")
-			return
+			response = verifyAction(fmt.Errorf("missing signal type"), "")
+			break
 		}
 
 		signal, err := service.GetSignal(signalType)
 		if err != nil {
-			log.Fatal(err)
-			return
+			response = verifyAction(err, "")
+			break
 		}
 
 		pidFloat, pidFloatExists := request["pid"].(float64)
 		if pidFloatExists {
 			intVal := int(pidFloat)
 			err := mgr.SignalServiceWithPID(intVal, signal)
-			message := "Service with PID:" + string(int(pidFloat)) + "and ID:" + mgr.GetID(intVal) + " stopped"
+			message := "Service with PID:" + strconv.Itoa(intVal) + "and ID:" + mgr.GetID(intVal) + " stopped"
 			response = verifyAction(err, message)
 			break
 		}
 
-		id, idExists := request["id"].(string)
-		if idExists {
-			log.Println("ID argument exists: ", id)
-			err := mgr.SignalServiceWithID(id, signal)
-			response = verifyAction(err, "Service "+id+" stopped")
+		id, err := requestedID(request)
+		if err == nil {
+			err = mgr.SignalServiceWithID(id, signal)
+		}
+		response = verifyAction(err, "Service "+id+" received "+signalType)
+
+	// Lifecycle of a registered service
+	case "stop", "restart", "try-restart", "remove":
+		id, err := requestedID(request)
+		if err != nil {
+			response = verifyAction(err, "")
+			break
+		}
+		timeout, err := requestedTimeout(request)
+		if err != nil {
+			response = verifyAction(err, "")
 			break
 		}
-		log.Fatal("No method for finding program found")
-		return
+
+		switch action {
+		case "stop":
+			err = mgr.StopService(id, timeout)
+			response = verifyAction(err, "Service "+id+" stopped")
+		case "restart":
+			err = mgr.RestartService(id, timeout)
+			response = verifyAction(err, "Service "+id+" restarted with pid: "+strconv.Itoa(mgr.GetPID(id)))
+		case "try-restart":
+			restarted, err := mgr.TryRestartService(id, timeout)
+			message := "Service " + id + " is not running, nothing to do"
+			if restarted {
+				message = "Service " + id + " restarted with pid: " + strconv.Itoa(mgr.GetPID(id))
+			}
+			response = verifyAction(err, message)
+		case "remove":
+			err = mgr.RemoveService(id, timeout)
+			response = verifyAction(err, "Service "+id+" removed")
+		}
 
 	// Check status of the service
 	case "status":
-		id, idExists := request["id"].(string)
-		if idExists {
-			fmt.Printf("ID argument exists: %s", id)
-			status := mgr.ServiceStatusByID(id)
-			response = map[string]interface{}{"status": "success", "message": status}
+		id, err := requestedID(request)
+		if err != nil {
+			response = verifyAction(err, "")
+			break
+		}
+		// Values from env_file are masked, they may be secrets
+		status := api.StatusResponse{
+			Response:     api.Response{Status: "success", Message: mgr.ServiceStatusByID(id)},
+			Environment:  mgr.ServiceEnvironment(id),
+			Namespaces:   mgr.ServiceNamespaces(id),
+			Capabilities: mgr.ServiceCapabilities(id),
+			Scheduling:   mgr.ServiceScheduling(id),
+		}
+		// Resource usage is only available while the process runs
+		if usage, err := mgr.ServiceUsage(id, argumentValue(request, "descendants", false)); err == nil {
+			status.Usage = &usage
+		}
+		response = api.Fields(status)
+	// Recorded before events are streamed by watchEvents or the HTTP API
+	case "watch":
+		if _, err := argumentArrayValue[string](request, "services"); err != nil {
+			response = verifyAction(err, "")
+			break
+		}
+		response = map[string]interface{}{"status": "success", "message": "Watching events"}
+	// Query the audit log
+	case "audit":
+		records, err := queryAudit(request)
+		response = verifyAction(err, fmt.Sprintf("%d records", len(records)))
+		if err == nil {
+			response["records"] = records
+		}
+	// Run the exec_reload hooks of a service
+	case "reload":
+		id, err := requestedID(request)
+		if err == nil {
+			err = mgr.ReloadService(id)
+		}
+		response = verifyAction(err, "Service "+id+" reloaded")
+	// Every registered service
+	case "list":
+		services := mgr.List()
+		response = api.Fields(api.ListResponse{
+			Response: api.Response{Status: "success", Message: fmt.Sprintf("%d services", len(services))},
+			Services: services,
+		})
+	// Captured output of a service
+	case "logs":
+		id, err := requestedID(request)
+		output := ""
+		if err == nil {
+			output, err = mgr.ServiceOutput(id)
+		}
+		if err != nil {
+			response = verifyAction(err, "")
 			break
 		}
-		pid, pidExists := request["pid"].(float64)
-		if pidExists {
-			status := mgr.ServiceStatusByPID(int(pid))
-			response = map[string]interface{}{"status": "success", "message": status}
+		response = api.Fields(api.LogsResponse{
+			Response: api.Response{Status: "success", Message: "Output of " + id},
+			Output:   output,
+		})
+	// Steps of the boot sequence
+	case "boot-log":
+		response = map[string]interface{}{"status": "success", "message": fmt.Sprintf("%d boot steps", len(bootLog)), "steps": bootLog, "log": boot.Format(bootLog)}
+	// fstab and configured mounts
+	case "mounts":
+		list := []mounts.Mount{}
+		if mountTable != nil {
+			list = mountTable.List()
+		}
+		response = map[string]interface{}{"status": "success", "message": fmt.Sprintf("%d mounts", len(list)), "mounts": list}
+	// Switch to another target
+	case "isolate":
+		target := argumentValue(request, "target", "")
+		if target == "" {
+			response = verifyAction(fmt.Errorf("missing target"), "")
 			break
 		}
-		log.Fatal("No method for finding program found")
+		started, stopped, err := mgr.Isolate(target)
+		response = verifyAction(err, fmt.Sprintf("Isolated target %s, started: %v, stopped: %v", target, started, stopped))
+	// Read config.toml again, running services keep their options
+	case "daemon-reload":
+		err := config.ReloadConfig()
+		if err == nil {
+			mgr.Events().Publish(events.ConfigReloaded, "", 0, "")
+		}
+		response = verifyAction(err, "Configuration reloaded")
+	// Execute a new daemon binary that takes over the running services, done
+	// by handleConnection once the response is sent
+	case "daemon-reexec":
+		binary, err := reexecTarget(argumentValue(request, "binary", ""))
+		response = verifyAction(err, "Re-executing "+binary)
+		if err == nil {
+			response["binary"] = binary
+		}
 	default:
 		response = map[string]interface{}{"status": "error", "message": "Unknown action"}
 	}
 
-	encoder := json.NewEncoder(conn)
-	err = encoder.Encode(response)
+	result, _ := response["status"].(string)
+	metrics.ObserveRequest(action, result, time.Since(received))
+	recordAudit(peer, action, request, response)
+	return response
+}
+
+// reexecVariable points a re-executed daemon to its handover file
+const reexecVariable = "OPS_CTRL_REEXEC"
+
+// reexecTarget returns the binary daemon-reexec executes, the running one by
+// default
+func reexecTarget(binary string) (string, error) {
+	if binary == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return "", fmt.Errorf("failed to find the daemon binary: %v", err)
+		}
+		binary = executable
+	}
+	return service.ResolveBinary(binary)
+}
+
+// reexec hands the services and the control socket over to binary and
+// executes it in place of this daemon, keeping the PID. Running services are
+// not restarted. It only returns on failure.
+func reexec(binary string) error {
+	unixListener, ok := control.(*net.UnixListener)
+	if !ok {
+		return fmt.Errorf("control socket can not be handed over")
+	}
+	controlFile, err := unixListener.File()
 	if err != nil {
-		log.Fatal("Failed to encode response: ", err)
+		return fmt.Errorf("failed to hand over control socket: %v", err)
+	}
+	defer controlFile.Close()
+
+	path := filepath.Join(config.GetConfig().StateDirectory(), "reexec.json")
+	if err := mgr.Handover(path, controlFile); err != nil {
+		return err
+	}
+	fmt.Printf("Executing %s\n", binary)
+	environment := append(os.Environ(), reexecVariable+"="+path)
+	err = syscall.Exec(binary, os.Args, environment)
+	os.Remove(path)
+	mgr.CancelHandover(controlFile)
+	return fmt.Errorf("failed to execute %s: %v", binary, err)
+}
+
+// socketPath is the control socket the CLI connects to
+const socketPath = "/tmp/ops-ctrl-daemon.sock"
+
+// listenControl listens on the control socket, a socket left behind by a
+// crashed daemon is replaced
+func listenControl() (net.Listener, error) {
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another daemon is listening on %s", socketPath)
 	}
+	os.Remove(socketPath)
+	return net.Listen("unix", socketPath)
+}
+
+// takeOver continues with the handover of a daemon that executed this one
+func takeOver(path string) (net.Listener, error) {
+	controlFile, err := mgr.TakeOver(path)
+	if err != nil {
+		return nil, err
+	}
+	defer controlFile.Close()
+	return net.FileListener(controlFile)
 }
 
 func main() {
+	// The daemon binary doubles as exec helper of the services
+	service.RunHelper()
+
 	tomlFile := "config.toml"
 	config.LoadConfig(tomlFile)
 
-	listener, err := net.Listen("unix", "/tmp/ops-ctrl-daemon.sock")
+	// As PID 1 the API filesystems and fstab mounts are needed before
+	// anything else
+	loadMounts(config.GetConfig().Mounts)
+	runBoot(config.GetConfig().Boot)
+
+	// A re-executed daemon continues with the state and control socket of its
+	// predecessor, otherwise it takes over the services of a previous daemon
+	// from the state directory before starting anything
+	var err error
+	stateDir := config.GetConfig().StateDirectory()
+	if path := os.Getenv(reexecVariable); path != "" {
+		os.Unsetenv(reexecVariable)
+		control, err = takeOver(path)
+	} else {
+		control, err = listenControl()
+		if err == nil {
+			if err := mgr.Restore(stateDir); err != nil {
+				fmt.Printf("Not restoring services: %v\n", err)
+			}
+		}
+	}
 	if err != nil {
 		log.Fatal("Failed to listen on socket:", err)
 	}
-	defer listener.Close()
+	defer control.Close()
 	fmt.Print("Service manager daemon started\n")
 
+	if err := mgr.EnableState(stateDir); err != nil {
+		log.Fatal("Failed to enable state persistence: ", err)
+	}
+
+	auditConfig := config.GetConfig().Audit
+	if auditConfig.Path == "" {
+		auditConfig.Path = audit.DefaultPath
+	}
+	if auditLog, err = audit.Open(auditConfig.Path, auditConfig.MaxSize, auditConfig.Keep); err != nil {
+		fmt.Printf("Requests are not audited: %v\n", err)
+	}
+
+	if err := service.BecomeSubreaper(); err != nil {
+		fmt.Println("Forking services can not be supervised:", err)
+	}
+
+	// Reap daemonized processes that were reparented to us. Adopted services
+	// are tracked by now, so their exit codes are not reaped here.
+	childChan := make(chan os.Signal, 1)
+	signal.Notify(childChan, syscall.SIGCHLD)
+	go func() {
+		for range childChan {
+			mgr.ReapOrphans()
+		}
+	}()
+
+	if apiConfig := config.GetConfig().API; apiConfig.Listen != "" {
+		tokens := make(map[string][]api.Scope)
+		for token, scopes := range apiConfig.Tokens {
+			for _, scope := range scopes {
+				tokens[token] = append(tokens[token], api.Scope(scope))
+			}
+		}
+		options := api.Options{Listen: apiConfig.Listen, TLSCert: apiConfig.TLSCert, TLSKey: apiConfig.TLSKey, Tokens: tokens}
+		if err := api.Serve(options, handleRequest, mgr.Events()); err != nil {
+			fmt.Println("HTTP API disabled:", err)
+		} else {
+			fmt.Printf("HTTP API available on %s%s\n", apiConfig.Listen, api.Prefix)
+		}
+	}
+
+	if listen := config.GetConfig().Metrics.Listen; listen != "" {
+		if err := metrics.Serve(listen, mgr); err != nil {
+			log.Fatal("Failed to start metrics endpoint: ", err)
+		}
+		fmt.Printf("Metrics available on %s/metrics\n", listen)
+	}
+
 	mgr.RunAutostart()
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		<-signalChan
-		fmt.Print("Shutting down service manager daemon")
-		listener.Close()
-		os.Exit(0)
+		shutdown(<-signalChan)
 	}()
 
 	for {
-		conn, err := listener.Accept()
+		conn, err := control.Accept()
 		if err != nil {
 			continue
 		}
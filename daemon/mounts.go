@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"ops-ctrl/pkg/config"
+	"ops-ctrl/pkg/mounts"
+	"ops-ctrl/pkg/service"
+)
+
+// mountTable holds the fstab and configured mounts, nil if fstab is invalid
+var mountTable *mounts.Table
+
+// loadMounts reads the mount table and lets services require its mounts
+func loadMounts(settings config.Mounts) {
+	declared := []mounts.Entry{}
+	for _, entry := range settings.Entries {
+		declared = append(declared, mounts.Entry{Source: entry.Source, Target: entry.Target, FSType: entry.FSType, Options: entry.Options})
+	}
+	table, err := mounts.NewTable(settings.Fstab, declared)
+	if err != nil {
+		fmt.Printf("Mounts are not managed: %v\n", err)
+		return
+	}
+	mountTable = table
+	service.RequireMounts = table.Require
+}
+
+// unmountAll unmounts in reverse order at shutdown once the services are
+// stopped
+func unmountAll() {
+	if mountTable == nil {
+		return
+	}
+	for _, line := range mountTable.UnmountAll() {
+		fmt.Println("Shutdown:", line)
+	}
+}
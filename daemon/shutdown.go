@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// shutdown ends the daemon on SIGTERM or SIGINT. After a boot every service
+// is stopped and the mounts are unmounted first. PID 1 must not exit, the
+// kernel would panic, so it powers off on SIGTERM and restarts on SIGINT,
+// which runBoot has Ctrl-Alt-Del send.
+func shutdown(received os.Signal) {
+	fmt.Print("Shutting down service manager daemon\n")
+	control.Close()
+	if !booted() {
+		os.Exit(0)
+	}
+
+	for _, line := range mgr.StopAll() {
+		fmt.Println("Shutdown:", line)
+	}
+	// The services no longer keep the filesystems busy
+	unmountAll()
+	syscall.Sync()
+	if os.Getpid() != 1 {
+		os.Exit(0)
+	}
+
+	command, name := syscall.LINUX_REBOOT_CMD_POWER_OFF, "Powering off"
+	if received == syscall.SIGINT {
+		command, name = syscall.LINUX_REBOOT_CMD_RESTART, "Restarting"
+	}
+	fmt.Printf("%s\n", name)
+	err := syscall.Reboot(command)
+	fmt.Printf("Failed to %s: %v\n", name, err)
+	for {
+		time.Sleep(time.Hour)
+	}
+}
@@ -2,4 +2,4 @@ module ops-ctrl
 
 go 1.22.5
 
-require github.com/BurntSushi/toml v1.4.0 // indirect
+require github.com/BurntSushi/toml v1.4.0
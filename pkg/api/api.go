@@ -0,0 +1,339 @@
+package api
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"ops-ctrl/pkg/audit"
+	"ops-ctrl/pkg/events"
+)
+
+// Handler performs a control request of peer and returns the response, the
+// same requests the control socket accepts
+type Handler func(peer audit.Peer, request map[string]interface{}) map[string]interface{}
+
+// Scope is a group of operations a token may use
+type Scope string
+
+const (
+	ScopeRead    Scope = "read"    // List, status, logs and events
+	ScopeControl Scope = "control" // Start, stop, restart, reload, remove and signal
+)
+
+// Options configures the API
+type Options struct {
+	Listen  string             // "tcp://host:port" or "unix:///path"
+	TLSCert string             // Certificate file, plain HTTP only on loopback without it
+	TLSKey  string             // Key file of TLSCert
+	Tokens  map[string][]Scope // Bearer tokens and what they may do
+}
+
+// route is one typed operation, the OpenAPI document is generated from these
+type route struct {
+	method   string
+	path     string
+	summary  string
+	scope    Scope
+	action   string
+	query    []parameter    // Query parameters passed on to the request
+	body     controlRequest // Typed request body, nil if there is none
+	response interface{}    // Typed response
+}
+
+// parameter is a query parameter of a route
+type parameter struct {
+	name        string
+	kind        string // OpenAPI type
+	description string
+}
+
+// Prefix of every route
+const Prefix = "/api/v1"
+
+var routes = []route{
+	{method: "GET", path: "/services", summary: "List services", scope: ScopeRead, action: "list", response: ListResponse{}},
+	{method: "POST", path: "/services", summary: "Start a service", scope: ScopeControl, action: "start", body: StartRequest{}, response: Response{}},
+	{method: "GET", path: "/services/{id}", summary: "Status and resource usage of a service", scope: ScopeRead, action: "status",
+		query: []parameter{{"descendants", "boolean", "Include the processes below the main PID in the usage"}}, response: StatusResponse{}},
+	{method: "GET", path: "/services/{id}/logs", summary: "Captured output of a service", scope: ScopeRead, action: "logs", response: LogsResponse{}},
+	{method: "POST", path: "/services/{id}/stop", summary: "Stop a service", scope: ScopeControl, action: "stop", body: StopRequest{}, response: Response{}},
+	{method: "POST", path: "/services/{id}/restart", summary: "Restart a service", scope: ScopeControl, action: "restart", body: StopRequest{}, response: Response{}},
+	{method: "POST", path: "/services/{id}/try-restart", summary: "Restart a service if it is running", scope: ScopeControl, action: "try-restart", body: StopRequest{}, response: Response{}},
+	{method: "DELETE", path: "/services/{id}", summary: "Stop and remove a service", scope: ScopeControl, action: "remove",
+		query: []parameter{{"timeout", "string", "Time before SIGKILL like 30s, default stop_timeout"}}, response: Response{}},
+	{method: "POST", path: "/services/{id}/reload", summary: "Run the reload hooks of a service", scope: ScopeControl, action: "reload", response: Response{}},
+	{method: "POST", path: "/services/{id}/signal", summary: "Send a signal to a service", scope: ScopeControl, action: "signal", body: SignalRequest{}, response: Response{}},
+}
+
+// eventsRoute streams the events, it's served separately as it isn't a
+// control request
+var eventsRoute = route{
+	method:  "GET",
+	path:    "/events",
+	summary: "Stream service events as server-sent events, resumable with Last-Event-ID",
+	scope:   ScopeRead,
+	query: []parameter{
+		{"since", "integer", "Replay the buffered events after this sequence number"},
+		{"service", "string", "Only events of this service, can be repeated"},
+	},
+	response: Event{},
+}
+
+// Serve exposes the API on options.Listen, it returns once the listener is up
+func Serve(options Options, handler Handler, bus *events.Bus) error {
+	network, address, found := strings.Cut(options.Listen, "://")
+	if !found {
+		network, address = "tcp", options.Listen
+	}
+	tlsEnabled := options.TLSCert != "" || options.TLSKey != ""
+	if !tlsEnabled && !local(network, address) {
+		return fmt.Errorf("refusing plain HTTP on %s, bearer tokens need tls_cert and tls_key off loopback", options.Listen)
+	}
+	if network == "unix" {
+		os.Remove(address)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", options.Listen, err)
+	}
+
+	if tlsEnabled {
+		certificate, err := tls.LoadX509KeyPair(options.TLSCert, options.TLSKey)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to load TLS certificate: %v", err)
+		}
+		listener = tls.NewListener(listener, &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		})
+	}
+
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.Handle(route.method+" "+Prefix+route.path, limitTime(authorize(options.Tokens, route.scope, handle(route, handler))))
+	}
+	// The event stream is the one response without a time limit
+	mux.Handle(eventsRoute.method+" "+Prefix+eventsRoute.path, authorize(options.Tokens, eventsRoute.scope, streamEvents(bus, handler)))
+	document := openAPI()
+	mux.Handle("GET "+Prefix+"/openapi.json", limitTime(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	})))
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			fmt.Println("HTTP API stopped:", err)
+		}
+	}()
+	return nil
+}
+
+const (
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 2 * time.Minute
+	requestTimeout    = time.Minute // Reading the body and writing the response
+)
+
+// limitTime bounds reading the request body and writing the response. It's
+// set per route since the server wide timeouts would also end event streams,
+// the deadline stays on the connection until the next request changes it.
+func limitTime(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		controller := http.NewResponseController(w)
+		deadline := time.Now().Add(requestTimeout)
+		controller.SetReadDeadline(deadline)
+		controller.SetWriteDeadline(deadline)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// local reports whether a listener is only reachable from this host, a unix
+// socket or a loopback address. An empty host listens on every interface.
+func local(network, address string) bool {
+	if strings.HasPrefix(network, "unix") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorize only lets requests with a bearer token that has scope through
+func authorize(tokens map[string][]Scope, scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || presented == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ops-ctrl"`)
+			writeJSON(w, http.StatusUnauthorized, Response{Status: "error", Message: "missing bearer token"})
+			return
+		}
+
+		var scopes []Scope
+		known := false
+		for token, tokenScopes := range tokens {
+			// Compares every token in constant time
+			if subtle.ConstantTimeCompare([]byte(token), []byte(presented)) == 1 {
+				scopes, known = tokenScopes, true
+			}
+		}
+		if !known {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ops-ctrl", error="invalid_token"`)
+			writeJSON(w, http.StatusUnauthorized, Response{Status: "error", Message: "unknown bearer token"})
+			return
+		}
+		for _, granted := range scopes {
+			if granted == scope {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		writeJSON(w, http.StatusForbidden, Response{Status: "error", Message: fmt.Sprintf("token lacks the %s scope", scope)})
+	})
+}
+
+// handle translates the HTTP request of route to a control request
+func handle(route route, handler Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := map[string]interface{}{}
+		if route.body != nil {
+			body, err := decodeBody(r, route.body)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, Response{Status: "error", Message: err.Error()})
+				return
+			}
+			request = body.request()
+		}
+		request["action"] = route.action
+		if id := r.PathValue("id"); id != "" {
+			request["id"] = id
+		}
+		for _, parameter := range route.query {
+			value := r.URL.Query().Get(parameter.name)
+			switch {
+			case value == "":
+			case parameter.kind == "boolean":
+				request[parameter.name] = value == "true" || value == "1"
+			default:
+				request[parameter.name] = value
+			}
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		response := handler(audit.APIPeer(r.RemoteAddr, token), request)
+		status := http.StatusOK
+		if response["status"] != "success" {
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, response)
+	})
+}
+
+// decodeBody decodes the body into a new value of the type of body, an empty
+// body is the zero value
+func decodeBody(r *http.Request, body controlRequest) (controlRequest, error) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	typed := reflect.New(reflect.TypeOf(body))
+	if err := decoder.Decode(typed.Interface()); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid request body: %v", err)
+	}
+	return typed.Elem().Interface().(controlRequest), nil
+}
+
+// streamEvents sends the events as server-sent events until the client
+// disconnects. A dropped slow client resumes with Last-Event-ID. The handler
+// sees a "watch" request first, so the stream is audited.
+func streamEvents(bus *events.Bus, handler Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSON(w, http.StatusInternalServerError, Response{Status: "error", Message: "streaming unsupported"})
+			return
+		}
+		// An earlier request on a kept alive connection leaves its write
+		// deadline behind
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		since := r.URL.Query().Get("since")
+		if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+			since = lastID
+		}
+		sequence := uint64(0)
+		if since != "" {
+			var err error
+			if sequence, err = strconv.ParseUint(since, 10, 64); err != nil {
+				writeJSON(w, http.StatusBadRequest, Response{Status: "error", Message: "invalid sequence number: " + since})
+				return
+			}
+		}
+
+		services := r.URL.Query()["service"]
+		request := map[string]interface{}{"action": "watch", "since": float64(sequence), "services": interfaces(services)}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if response := handler(audit.APIPeer(r.RemoteAddr, token), request); response["status"] != "success" {
+			writeJSON(w, http.StatusBadRequest, response)
+			return
+		}
+
+		subscription, backlog := bus.Subscribe(sequence, services)
+		defer subscription.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		for _, event := range backlog {
+			writeEvent(w, event)
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case event, ok := <-subscription.C:
+				if !ok {
+					return
+				}
+				writeEvent(w, event)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event) {
+	data, _ := json.Marshal(event)
+	if event.Seq > 0 {
+		fmt.Fprintf(w, "id: %d\n", event.Seq)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Kind, data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
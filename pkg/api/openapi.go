@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// openAPI generates the OpenAPI 3 document of the routes
+func openAPI() []byte {
+	paths := map[string]map[string]interface{}{}
+	for _, route := range append(routes, eventsRoute) {
+		path := Prefix + route.path
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+
+		parameters := []interface{}{}
+		if strings.Contains(route.path, "{id}") {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        "id",
+				"in":          "path",
+				"required":    true,
+				"description": "Service ID, a unique prefix or alias",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, parameter := range route.query {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        parameter.name,
+				"in":          "query",
+				"description": parameter.description,
+				"schema":      map[string]interface{}{"type": parameter.kind},
+			})
+		}
+
+		contentType := "application/json"
+		if route.path == eventsRoute.path {
+			contentType = "text/event-stream"
+		}
+		operation := map[string]interface{}{
+			"summary":     route.summary,
+			"operationId": operationID(route),
+			"parameters":  parameters,
+			"security":    []interface{}{map[string]interface{}{"bearer": []string{string(route.scope)}}},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Success",
+					"content":     map[string]interface{}{contentType: map[string]interface{}{"schema": schema(reflect.TypeOf(route.response))}},
+				},
+				"400": errorResponse("The operation failed"),
+				"401": errorResponse("Missing or unknown bearer token"),
+				"403": errorResponse("The token lacks the scope " + string(route.scope)),
+			},
+		}
+		if route.body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": schema(reflect.TypeOf(route.body))}},
+			}
+		}
+		paths[path][strings.ToLower(route.method)] = operation
+	}
+
+	document := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ops-ctrl",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Tokens and their scopes (read, control) are configured in [api.tokens]",
+				},
+			},
+		},
+	}
+	content, _ := json.MarshalIndent(document, "", "  ")
+	return content
+}
+
+func operationID(route route) string {
+	if route.action == "" {
+		return "events"
+	}
+	return route.action
+}
+
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema(reflect.TypeOf(Response{}))}},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema describes a Go type as a JSON schema, using the json tags of struct
+// fields and their doc tag as description
+func schema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		return schema(t.Elem())
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		addFields(t, properties, &required)
+		object := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			object["required"] = required
+		}
+		return object
+	}
+	return map[string]interface{}{}
+}
+
+// addFields adds the fields of struct t, embedded structs are flattened like
+// encoding/json does
+func addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := schema(field.Type)
+		if doc := field.Tag.Get("doc"); doc != "" {
+			property["description"] = doc
+		}
+		properties[name] = property
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+
+	"ops-ctrl/pkg/events"
+	"ops-ctrl/pkg/manager"
+	"ops-ctrl/pkg/procfs"
+)
+
+// controlRequest is a typed body that is translated to a control socket request
+type controlRequest interface {
+	request() map[string]interface{}
+}
+
+// StartRequest registers and starts a service, like "start" on the control socket
+type StartRequest struct {
+	ID         string   `json:"id,omitempty" doc:"Unique ID, derived from the alias or binary if empty"`
+	Alias      string   `json:"alias,omitempty" doc:"Alias or service definition from config.toml"`
+	Binary     string   `json:"binary,omitempty" doc:"Program binary, overrides the alias"`
+	Args       []string `json:"args,omitempty"`
+	Env        []string `json:"env,omitempty" doc:"Environment variables as NAME=value"`
+	EnvFile    []string `json:"env_file,omitempty" doc:"dotenv files, optional with a - prefix"`
+	WorkingDir string   `json:"working_dir,omitempty"`
+	Type       string   `json:"type,omitempty" doc:"simple, exec, forking or notify"`
+	PIDFile    string   `json:"pid_file,omitempty"`
+	LogPath    string   `json:"log_path,omitempty"`
+	User       string   `json:"user,omitempty"`
+	Group      string   `json:"group,omitempty"`
+	Replace    bool     `json:"replace,omitempty" doc:"Stop and replace a service with the same ID"`
+}
+
+func (r StartRequest) request() map[string]interface{} {
+	request := map[string]interface{}{
+		"action":           "start",
+		"id":               r.ID,
+		"program_argument": interfaces(r.Args),
+		"env":              interfaces(r.Env),
+		"env_file":         interfaces(r.EnvFile),
+		"binary":           r.Binary,
+		"working_dir":      r.WorkingDir,
+		"type":             r.Type,
+		"pid_file":         r.PIDFile,
+		"log_path":         r.LogPath,
+		"user":             r.User,
+		"group":            r.Group,
+		"replace":          r.Replace,
+	}
+	if r.Alias != "" {
+		request["alias"] = r.Alias
+	}
+	return request
+}
+
+// StopRequest stops, restarts or removes a service
+type StopRequest struct {
+	Timeout string `json:"timeout,omitempty" doc:"Time before SIGKILL like 30s, default stop_timeout"`
+}
+
+func (r StopRequest) request() map[string]interface{} {
+	return map[string]interface{}{"timeout": r.Timeout}
+}
+
+// SignalRequest sends a signal to the main process of a service
+type SignalRequest struct {
+	Signal string `json:"signal" doc:"Signal name like SIGHUP"`
+}
+
+func (r SignalRequest) request() map[string]interface{} {
+	return map[string]interface{}{"action": "signal", "signalType": r.Signal}
+}
+
+// Response is the result of every operation
+type Response struct {
+	Status  string `json:"status" doc:"success or error"`
+	Message string `json:"message"`
+}
+
+// ListResponse lists every service
+type ListResponse struct {
+	Response
+	Services []manager.ServiceSummary `json:"services"`
+}
+
+// StatusResponse is the state of one service
+type StatusResponse struct {
+	Response
+	Environment  []string          `json:"environment" doc:"Environment of the last start as NAME=value, values from env_file are masked"`
+	Usage        *procfs.Usage     `json:"usage,omitempty" doc:"Only while the service runs"`
+	Namespaces   map[string]string `json:"namespaces,omitempty" doc:"Configured namespaces by kind like net:[4026532290], empty while the service doesn't run"`
+	Capabilities map[string]string `json:"capabilities,omitempty" doc:"Capability sets of the main process"`
+	Scheduling   map[string]string `json:"scheduling,omitempty" doc:"Scheduling settings of the main process"`
+}
+
+// LogsResponse is the captured output of the last start
+type LogsResponse struct {
+	Response
+	Output string `json:"output"`
+}
+
+// Fields converts a typed response to the map the control socket sends, so
+// both interfaces return what the OpenAPI document describes
+func Fields(response interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	data, err := json.Marshal(response)
+	if err == nil {
+		err = json.Unmarshal(data, &fields)
+	}
+	if err != nil {
+		return map[string]interface{}{"status": "error", "message": err.Error()}
+	}
+	return fields
+}
+
+// Event is sent as the data of a server-sent event
+type Event events.Event
+
+// interfaces converts to the []interface{} a decoded control request contains
+func interfaces(values []string) []interface{} {
+	items := make([]interface{}, len(values))
+	for i, value := range values {
+		items[i] = value
+	}
+	return items
+}
@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"ops-ctrl/pkg/securedir"
+)
+
+// Defaults of the audit configuration
+const (
+	DefaultPath    = "/var/log/ops-ctrl/audit.log"
+	DefaultMaxSize = 10 << 20 // Bytes before the log is rotated
+	DefaultKeep    = 5        // Rotated files kept, audit.log.1 is the newest
+)
+
+// Peer is who sent a request
+type Peer struct {
+	Source string `json:"source"`           // "socket" or "api"
+	UID    int    `json:"uid"`              // -1 if unknown
+	GID    int    `json:"gid"`              // -1 if unknown
+	PID    int    `json:"pid,omitempty"`    // Peer process on the control socket
+	Exe    string `json:"exe,omitempty"`    // Executable of PID
+	Remote string `json:"remote,omitempty"` // Address of an API client
+	Token  string `json:"token,omitempty"`  // Fingerprint of the API token
+}
+
+// Record is one line of the audit log
+type Record struct {
+	Time    time.Time              `json:"time"`
+	Peer    Peer                   `json:"peer"`
+	Action  string                 `json:"action"`
+	Service string                 `json:"service,omitempty"` // Target of the action
+	Params  map[string]interface{} `json:"params,omitempty"`
+	Result  string                 `json:"result"` // "success" or "error"
+	Message string                 `json:"message,omitempty"`
+}
+
+// Log appends records to a file and rotates it
+type Log struct {
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+	mu      sync.Mutex
+}
+
+// Open opens the audit log for appending, zero maxSize and keep use the
+// defaults
+func Open(path string, maxSize int64, keep int) (*Log, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	// Other users must not be able to replace the log
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %v", err)
+	}
+	if err := securedir.Check(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("invalid audit directory: %v", err)
+	}
+	log := &Log{path: path, maxSize: maxSize, keep: keep}
+	if err := log.open(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// open opens the current file, which must be a regular file of the daemon's
+// user. Its mode is set to 0600.
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !info.Mode().IsRegular() || (ok && int(stat.Uid) != os.Geteuid()) {
+		file.Close()
+		return fmt.Errorf("audit log %s is not a regular file of UID %d", l.path, os.Geteuid())
+	}
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Path returns the path of the current file
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Write appends the record as one JSON line, rotating the file first when it
+// would grow beyond the maximum size
+func (l *Log) Write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	count, err := l.file.Write(line)
+	l.size += int64(count)
+	if err != nil {
+		return fmt.Errorf("failed to write audit record: %v", err)
+	}
+	return nil
+}
+
+// rotate renames audit.log to audit.log.1, audit.log.1 to audit.log.2 and so
+// on, dropping the oldest
+func (l *Log) rotate() error {
+	l.file.Close()
+	os.Remove(rotatedPath(l.path, l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		os.Rename(rotatedPath(l.path, i), rotatedPath(l.path, i+1))
+	}
+	if err := os.Rename(l.path, rotatedPath(l.path, 1)); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %v", err)
+	}
+	return l.open()
+}
+
+func rotatedPath(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}
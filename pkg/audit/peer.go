@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// SocketPeer identifies the process on the other end of a unix socket with
+// SO_PEERCRED, the executable is read from /proc
+func SocketPeer(conn net.Conn) Peer {
+	peer := Peer{Source: "socket", UID: -1, GID: -1}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peer
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return peer
+	}
+
+	var credentials *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		credentials, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credentials == nil {
+		return peer
+	}
+	peer.UID = int(credentials.Uid)
+	peer.GID = int(credentials.Gid)
+	peer.PID = int(credentials.Pid)
+	peer.Exe, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", peer.PID))
+	return peer
+}
+
+// APIPeer identifies an HTTP API client by its address and a fingerprint of
+// its token, the token itself is never logged
+func APIPeer(remote string, token string) Peer {
+	sum := sha256.Sum256([]byte(token))
+	return Peer{
+		Source: "api",
+		UID:    -1,
+		GID:    -1,
+		Remote: remote,
+		Token:  "sha256:" + hex.EncodeToString(sum[:])[:12],
+	}
+}
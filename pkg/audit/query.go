@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// Filter selects records, zero values match everything
+type Filter struct {
+	Since   time.Time
+	Until   time.Time
+	UID     *int
+	Service string
+	Limit   int // Only the newest records, 0 for all
+}
+
+func (f Filter) matches(record Record) bool {
+	if !f.Since.IsZero() && record.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && record.Time.After(f.Until) {
+		return false
+	}
+	if f.UID != nil && record.Peer.UID != *f.UID {
+		return false
+	}
+	return f.Service == "" || record.Service == f.Service
+}
+
+// Query returns the matching records of the log and its rotated files, the
+// oldest first
+func (l *Log) Query(filter Filter) ([]Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records := []Record{}
+	paths := []string{}
+	for i := l.keep; i >= 1; i-- {
+		paths = append(paths, rotatedPath(l.path, i))
+	}
+	paths = append(paths, l.path)
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var record Record
+			if json.Unmarshal(scanner.Bytes(), &record) != nil {
+				// A torn line after a crash
+				continue
+			}
+			if filter.matches(record) {
+				records = append(records, record)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[len(records)-filter.Limit:]
+	}
+	return records, nil
+}
@@ -0,0 +1,209 @@
+package boot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"ops-ctrl/pkg/mounts"
+	"ops-ctrl/pkg/sandbox"
+)
+
+// Results of a step
+const (
+	StatusDone    = "done"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// HostnameFile is read for the hostname when the configuration sets none
+const HostnameFile = "/etc/hostname"
+
+// Options configures the sequence
+type Options struct {
+	Hostname string        // Set at boot, HostnameFile by default
+	Skip     []string      // Names of steps left out
+	Mounts   *mounts.Table // fstab and configured mounts, mounted last
+}
+
+// Step is one entry of the boot log
+type Step struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Detail   string        `json:"detail,omitempty"` // Why it was skipped or failed
+	Duration time.Duration `json:"duration"`
+}
+
+// apiMount is an API filesystem mounted at boot
+type apiMount struct {
+	name    string
+	target  string
+	fstype  string
+	flags   uintptr
+	data    string
+	mkdir   bool // Create target, it is inside a filesystem mounted before
+	dirMode os.FileMode
+}
+
+// apiMounts are in the order they depend on each other
+var apiMounts = []apiMount{
+	{"proc", "/proc", "proc", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, "", false, 0},
+	{"sys", "/sys", "sysfs", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, "", false, 0},
+	{"dev", "/dev", "devtmpfs", syscall.MS_NOSUID, "mode=0755", false, 0},
+	{"devpts", "/dev/pts", "devpts", syscall.MS_NOSUID | syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620,gid=5", true, 0755},
+	{"run", "/run", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV, "mode=0755", false, 0},
+	{"cgroup2", "/sys/fs/cgroup", "cgroup2", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, "nsdelegate", true, 0755},
+}
+
+// StepNames lists the steps in the order they run
+func StepNames() []string {
+	names := []string{}
+	for _, m := range apiMounts {
+		names = append(names, m.name)
+	}
+	return append(names, "hostname", "loopback", "mounts")
+}
+
+// Validate checks the names of the skipped steps
+func (o Options) Validate() error {
+	for _, name := range o.Skip {
+		known := false
+		for _, step := range StepNames() {
+			known = known || step == name
+		}
+		if !known {
+			return fmt.Errorf("unknown boot step %s, use one of %s", name, strings.Join(StepNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// Run performs every step that isn't skipped, a failed step doesn't stop the
+// ones after it. Steps that are already done, like the mounts of a container,
+// are skipped, so it can run again.
+func Run(options Options) []Step {
+	skipped := make(map[string]bool)
+	for _, name := range options.Skip {
+		skipped[name] = true
+	}
+
+	log := []Step{}
+	run := func(name string, step func() (string, error)) {
+		started := time.Now()
+		entry := Step{Name: name, Status: StatusDone}
+		if skipped[name] {
+			entry.Status, entry.Detail = StatusSkipped, "disabled in [boot]"
+		} else if detail, err := step(); err != nil {
+			entry.Status, entry.Detail = StatusFailed, err.Error()
+		} else if detail != "" {
+			entry.Status, entry.Detail = StatusSkipped, detail
+		}
+		entry.Duration = time.Since(started)
+		log = append(log, entry)
+	}
+
+	for _, m := range apiMounts {
+		m := m
+		run(m.name, m.apply)
+	}
+	run("hostname", func() (string, error) { return setHostname(options.Hostname) })
+	run("loopback", func() (string, error) { return "", sandbox.Loopback() })
+	run("mounts", func() (string, error) { return mountAll(options.Mounts) })
+	return log
+}
+
+// apply mounts the filesystem unless its target is a mount point already.
+// It returns why it was skipped.
+func (m apiMount) apply() (string, error) {
+	if m.mkdir {
+		if err := os.MkdirAll(m.target, m.dirMode); err != nil {
+			return "", fmt.Errorf("failed to create %s: %v", m.target, err)
+		}
+	}
+	mounted, err := isMountPoint(m.target)
+	if err != nil {
+		return "", err
+	}
+	if mounted {
+		return "already mounted", nil
+	}
+	if err := syscall.Mount(m.fstype, m.target, m.fstype, m.flags, m.data); err != nil {
+		return "", fmt.Errorf("failed to mount %s on %s: %v", m.fstype, m.target, err)
+	}
+	return "", nil
+}
+
+// isMountPoint compares the device with the one of the parent directory,
+// which works before /proc is mounted
+func isMountPoint(path string) (bool, error) {
+	var target, parent syscall.Stat_t
+	if err := syscall.Stat(path, &target); err != nil {
+		return false, fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	if err := syscall.Stat(filepath.Dir(path), &parent); err != nil {
+		return false, fmt.Errorf("failed to stat %s: %v", filepath.Dir(path), err)
+	}
+	return target.Dev != parent.Dev || target.Ino == parent.Ino, nil
+}
+
+// setHostname sets hostname or the one of HostnameFile, it returns why it
+// was skipped
+func setHostname(hostname string) (string, error) {
+	if hostname == "" {
+		content, err := os.ReadFile(HostnameFile)
+		if os.IsNotExist(err) {
+			return "no hostname configured and no " + HostnameFile, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %v", HostnameFile, err)
+		}
+		// Comments are allowed, the first other line is the name
+		for _, line := range strings.Split(string(content), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				hostname = line
+				break
+			}
+		}
+		if hostname == "" {
+			return HostnameFile + " is empty", nil
+		}
+	}
+	if current, err := os.Hostname(); err == nil && current == hostname {
+		return "already set to " + hostname, nil
+	}
+	if err := syscall.Sethostname([]byte(hostname)); err != nil {
+		return "", fmt.Errorf("failed to set hostname %s: %v", hostname, err)
+	}
+	return "", nil
+}
+
+// mountAll mounts the table, it returns why it was skipped
+func mountAll(table *mounts.Table) (string, error) {
+	if table == nil {
+		return "no mount table", nil
+	}
+	mounted, err := table.MountAll()
+	if err != nil {
+		return "", err
+	}
+	if mounted == 0 {
+		return "nothing to mount", nil
+	}
+	return "", nil
+}
+
+// Format returns the boot log as lines for the console
+func Format(log []Step) string {
+	var builder strings.Builder
+	for _, step := range log {
+		fmt.Fprintf(&builder, "%-10s %-8s %s", step.Name, step.Status, step.Duration.Round(time.Microsecond))
+		if step.Detail != "" {
+			fmt.Fprintf(&builder, " (%s)", step.Detail)
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
@@ -1,20 +1,78 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"sync"
 
 	"github.com/BurntSushi/toml"
+
+	"ops-ctrl/pkg/service"
 )
 
 type Config struct {
-	Aliases   map[string]string `toml:"aliases"`
-	Autostart map[string]string `toml:"autostart"`
+	DefaultTarget string                     `toml:"default_target"` // Target reached at boot
+	Aliases       map[string]string          `toml:"aliases"`
+	Autostart     map[string]string          `toml:"autostart"`
+	Services      map[string]service.Options `toml:"services"` // Service definitions usable with "-a"
+	Targets       map[string]Target          `toml:"targets"`  // Groups of services, see "isolate"
+	Metrics       Metrics                    `toml:"metrics"`
+	API           API                        `toml:"api"`
+	Audit         Audit                      `toml:"audit"`
+	Boot          Boot                       `toml:"boot"`      // Early boot steps, see pkg/boot
+	Mounts        Mounts                     `toml:"mounts"`    // fstab and declared mounts, see pkg/mounts
+	StateDir      string                     `toml:"state_dir"` // Services are persisted here, see DefaultStateDir
+}
+
+// DefaultStateDir is used when state_dir is not set
+const DefaultStateDir = "/var/lib/ops-ctrl"
+
+// API configures the HTTP API
+type API struct {
+	Listen  string              `toml:"listen"`   // "tcp://host:port" or "unix:///path", empty disables it
+	TLSCert string              `toml:"tls_cert"` // Certificate file, plain HTTP without it
+	TLSKey  string              `toml:"tls_key"`
+	Tokens  map[string][]string `toml:"tokens"` // Bearer token to scopes, "read" and "control"
+}
+
+// Audit configures the audit log of the control requests
+type Audit struct {
+	Path    string `toml:"path"`     // JSON lines file, default audit.DefaultPath
+	MaxSize int64  `toml:"max_size"` // Bytes before rotating, default 10 MiB
+	Keep    int    `toml:"keep"`     // Rotated files kept, default 5
+}
+
+// Boot configures the early boot sequence of PID 1
+type Boot struct {
+	Enabled  *bool    `toml:"enabled"`  // Run the sequence, by default only as PID 1
+	Hostname string   `toml:"hostname"` // Set at boot, /etc/hostname by default
+	Skip     []string `toml:"skip"`     // Steps left out: proc, sys, dev, devpts, run, cgroup2, hostname, loopback or mounts
+}
+
+// Mounts configures the filesystems mounted at boot and for services
+type Mounts struct {
+	Fstab   string       `toml:"fstab"` // fstab(5) file, /etc/fstab by default, "-" for none
+	Entries []MountEntry `toml:"entry"` // Replace fstab entries of the same mount point
+}
+
+// MountEntry is a filesystem declared in the configuration
+type MountEntry struct {
+	Source  string `toml:"source"`
+	Target  string `toml:"target"`
+	FSType  string `toml:"fstype"`
+	Options string `toml:"options"` // Like in fstab, "defaults" if empty
+}
+
+// Metrics configures the Prometheus endpoint
+type Metrics struct {
+	Listen string `toml:"listen"` // "tcp://127.0.0.1:9323" or "unix:///path", empty disables it
 }
 
 var (
-	config   Config
-	loadOnce sync.Once
+	config     Config
+	configPath string
+	loadOnce   sync.Once
+	mu         sync.RWMutex
 )
 
 func LoadConfig(filePath string) {
@@ -22,9 +80,51 @@ func LoadConfig(filePath string) {
 		if _, err := toml.DecodeFile(filePath, &config); err != nil {
 			log.Fatalf("Error loading configuration: %v", err)
 		}
+		configPath = filePath
 	})
 }
 
+// ReloadConfig reads the file given to LoadConfig again. The old
+// configuration stays in use if the file is invalid.
+func ReloadConfig() error {
+	var reloaded Config
+	if _, err := toml.DecodeFile(configPath, &reloaded); err != nil {
+		return fmt.Errorf("error loading configuration: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	config = reloaded
+	return nil
+}
+
+// StateDirectory returns state_dir or DefaultStateDir
+func (c Config) StateDirectory() string {
+	if c.StateDir == "" {
+		return DefaultStateDir
+	}
+	return c.StateDir
+}
+
 func GetConfig() Config {
+	mu.RLock()
+	defer mu.RUnlock()
 	return config
 }
+
+// ServiceDefinition returns the definition of name. Instances like
+// "worker@orders" are created from the "worker@" template on demand.
+func (c Config) ServiceDefinition(name string) (service.Options, bool) {
+	if definition, exists := c.Services[name]; exists && !service.IsTemplate(name) {
+		return definition, true
+	}
+	template, _, isInstance := service.SplitInstance(name)
+	if !isInstance {
+		return service.Options{}, false
+	}
+	definition, exists := c.Services[template]
+	if !exists {
+		return service.Options{}, false
+	}
+	return definition.Instantiate(name), true
+}
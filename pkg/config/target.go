@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KernelTargetParameter selects the boot target on the kernel command line
+const KernelTargetParameter = "ops-ctrl.target"
+
+// Target groups services into a boot state
+type Target struct {
+	Requires []string `toml:"requires"` // Targets that are reached before this one
+	Services []string `toml:"services"` // Service definitions started by this target
+}
+
+// BootTarget returns the target selected on the kernel command line, or the
+// default_target from the config. Empty if neither is set.
+func (c Config) BootTarget() string {
+	if cmdline, err := os.ReadFile("/proc/cmdline"); err == nil {
+		for _, parameter := range strings.Fields(string(cmdline)) {
+			if value, found := strings.CutPrefix(parameter, KernelTargetParameter+"="); found {
+				return value
+			}
+		}
+	}
+	return c.DefaultTarget
+}
+
+// TargetServices returns the services of the target and every target it
+// requires, required targets first and each service once
+func (c Config) TargetServices(name string) ([]string, error) {
+	services := []string{}
+	added := make(map[string]bool)
+	visiting := make(map[string]bool)
+	visited := make(map[string]bool)
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		if visited[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("dependency cycle at target %s", name)
+		}
+		target, exists := c.Targets[name]
+		if !exists {
+			return fmt.Errorf("unknown target: %s", name)
+		}
+		visiting[name] = true
+		for _, required := range target.Requires {
+			if err := visit(required); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+
+		for _, service := range target.Services {
+			if !added[service] {
+				added[service] = true
+				services = append(services, service)
+			}
+		}
+		return nil
+	}
+
+	if err := visit(name); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
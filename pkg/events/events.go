@@ -0,0 +1,152 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Kind string
+
+const (
+	Started        Kind = "started"         // Process was spawned
+	Ready          Kind = "ready"           // Service counts as started for its type
+	Exited         Kind = "exited"          // Process exited cleanly on its own
+	Failed         Kind = "failed"          // Process failed to start or exited uncleanly
+	Restarting     Kind = "restarting"      // Restart policy schedules a restart
+	Stopped        Kind = "stopped"         // Service was stopped on request
+	Adopted        Kind = "adopted"         // Running process was taken over after a daemon restart
+	Reloaded       Kind = "reloaded"        // Reload hooks of the service succeeded
+	Health         Kind = "health"          // Health of the service changed
+	ConfigReloaded Kind = "config-reloaded" // config.toml was read again
+	Lost           Kind = "lost"            // Requested events are no longer buffered
+)
+
+// Event is sent to watchers as one JSON line
+type Event struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Kind    Kind      `json:"kind"`
+	Service string    `json:"service,omitempty"`
+	PID     int       `json:"pid,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// subscriberBuffer is how many events a slow watcher may fall behind before
+// it's dropped. It can resume with the sequence number of its last event.
+const subscriberBuffer = 256
+
+// Bus keeps the latest events in a ring buffer and fans them out to watchers
+type Bus struct {
+	ring        []Event
+	next        int // Position in ring for the next event
+	count       int
+	seq         uint64
+	subscribers map[*Subscription]bool
+	mu          sync.Mutex
+}
+
+func NewBus(size int) *Bus {
+	return &Bus{
+		ring:        make([]Event, size),
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Subscription receives the events that match its filter
+type Subscription struct {
+	C      chan Event
+	filter map[string]bool
+	bus    *Bus
+}
+
+// Publish records an event and sends it to every matching watcher
+func (b *Bus) Publish(kind Kind, service string, pid int, message string) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event := Event{
+		Seq:     b.seq,
+		Time:    time.Now(),
+		Kind:    kind,
+		Service: service,
+		PID:     pid,
+		Message: message,
+	}
+	b.ring[b.next] = event
+	b.next = (b.next + 1) % len(b.ring)
+	if b.count < len(b.ring) {
+		b.count++
+	}
+
+	for subscription := range b.subscribers {
+		if !subscription.matches(event) {
+			continue
+		}
+		select {
+		case subscription.C <- event:
+		default:
+			// Too slow, the watcher sees the channel close and can resume
+			delete(b.subscribers, subscription)
+			close(subscription.C)
+		}
+	}
+	return event
+}
+
+// Subscribe returns the buffered events after since, zero means only new
+// events, and a subscription for the live ones. services limits the events
+// to those services, empty means all. If events after since were already
+// overwritten a Lost event comes first.
+func (b *Bus) Subscribe(since uint64, services []string) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscription := &Subscription{
+		C:   make(chan Event, subscriberBuffer),
+		bus: b,
+	}
+	if len(services) > 0 {
+		subscription.filter = make(map[string]bool)
+		for _, service := range services {
+			subscription.filter[service] = true
+		}
+	}
+
+	backlog := []Event{}
+	if since > 0 {
+		oldest := b.seq - uint64(b.count) + 1
+		if since+1 < oldest {
+			backlog = append(backlog, Event{
+				Time:    time.Now(),
+				Kind:    Lost,
+				Message: "events before the buffered ones were dropped",
+			})
+		}
+		for i := 0; i < b.count; i++ {
+			event := b.ring[(b.next-b.count+i+len(b.ring))%len(b.ring)]
+			if event.Seq > since && subscription.matches(event) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	b.subscribers[subscription] = true
+	return subscription, backlog
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if s.bus.subscribers[s] {
+		delete(s.bus.subscribers, s)
+		close(s.C)
+	}
+}
+
+func (s *Subscription) matches(event Event) bool {
+	// Daemon wide events go to every watcher
+	return s.filter == nil || event.Service == "" || s.filter[event.Service]
+}
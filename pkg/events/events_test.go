@@ -0,0 +1,132 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sequences returns the sequence numbers of events, Lost events as 0
+func sequences(events []Event) []uint64 {
+	list := []uint64{}
+	for _, event := range events {
+		list = append(list, event.Seq)
+	}
+	return list
+}
+
+func TestSubscribeBacklog(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		published int
+		since     uint64
+		services  []string
+		want      []uint64
+		wantLost  bool
+	}{
+		{name: "only new events", size: 4, published: 3, since: 0, want: []uint64{}},
+		{name: "after since", size: 4, published: 3, since: 1, want: []uint64{2, 3}},
+		{name: "nothing after the latest", size: 4, published: 3, since: 3, want: []uint64{}},
+		{name: "ring wrapped", size: 4, published: 10, since: 6, want: []uint64{7, 8, 9, 10}},
+		{name: "overwritten events", size: 4, published: 10, since: 2, want: []uint64{0, 7, 8, 9, 10}, wantLost: true},
+		{name: "one event overwritten", size: 4, published: 10, since: 5, want: []uint64{0, 7, 8, 9, 10}, wantLost: true},
+		{name: "empty bus", size: 4, published: 0, since: 5, want: []uint64{}},
+		// Odd events belong to "a", even ones to "b", every third is daemon wide
+		{name: "filtered", size: 8, published: 6, since: 1, services: []string{"a"}, want: []uint64{3, 5, 6}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bus := NewBus(test.size)
+			for seq := 1; seq <= test.published; seq++ {
+				service := "a"
+				switch {
+				case seq%3 == 0:
+					service = ""
+				case seq%2 == 0:
+					service = "b"
+				}
+				bus.Publish(Started, service, seq, "")
+			}
+
+			subscription, backlog := bus.Subscribe(test.since, test.services)
+			defer subscription.Close()
+			if got := sequences(backlog); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Subscribe() backlog = %v, want %v", got, test.want)
+			}
+			lost := len(backlog) > 0 && backlog[0].Kind == Lost
+			if lost != test.wantLost {
+				t.Errorf("Subscribe() lost = %v, want %v", lost, test.wantLost)
+			}
+		})
+	}
+}
+
+func TestPublishLive(t *testing.T) {
+	bus := NewBus(4)
+	all, _ := bus.Subscribe(0, nil)
+	defer all.Close()
+	filtered, _ := bus.Subscribe(0, []string{"a"})
+	defer filtered.Close()
+
+	bus.Publish(Started, "a", 1, "")
+	bus.Publish(Started, "b", 2, "")
+	bus.Publish(ConfigReloaded, "", 0, "")
+
+	if got := sequences(drain(all)); !reflect.DeepEqual(got, []uint64{1, 2, 3}) {
+		t.Errorf("unfiltered subscription got %v", got)
+	}
+	if got := sequences(drain(filtered)); !reflect.DeepEqual(got, []uint64{1, 3}) {
+		t.Errorf("filtered subscription got %v", got)
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	bus := NewBus(4)
+	slow, _ := bus.Subscribe(0, nil)
+	for i := 0; i <= subscriberBuffer; i++ {
+		bus.Publish(Started, "a", i, "")
+	}
+
+	received := 0
+	for range slow.C {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("slow subscription received %d events before it was closed, want %d", received, subscriberBuffer)
+	}
+	// Closing a dropped subscription again must not panic
+	slow.Close()
+
+	// It can resume from the buffered events
+	resumed, backlog := bus.Subscribe(uint64(subscriberBuffer), nil)
+	defer resumed.Close()
+	if got := sequences(backlog); !reflect.DeepEqual(got, []uint64{subscriberBuffer + 1}) {
+		t.Errorf("resumed backlog = %v", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	bus := NewBus(4)
+	subscription, _ := bus.Subscribe(0, nil)
+	subscription.Close()
+	subscription.Close()
+	if _, open := <-subscription.C; open {
+		t.Error("channel is still open after Close")
+	}
+	// Publishing after Close must not send to the closed channel
+	bus.Publish(Started, "a", 1, "")
+}
+
+// drain returns the events that are waiting in a subscription
+func drain(subscription *Subscription) []Event {
+	events := []Event{}
+	for {
+		select {
+		case event := <-subscription.C:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
@@ -3,112 +3,227 @@ package manager
 import (
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
 	"ops-ctrl/pkg/config"
+	"ops-ctrl/pkg/events"
 	"ops-ctrl/pkg/service"
 )
 
-const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-
-// init seeds the random number generator with the current time.
-func init() {
-	rand.NewSource(time.Now().UnixNano())
-}
+// eventBufferSize is how many events reconnecting watchers can catch up on
+const eventBufferSize = 1024
 
 type Manager struct {
 	services map[string]*service.Service
+	target   string        // Last target that was reached
+	events   *events.Bus   // State changes of the services
+	dirty    chan struct{} // Signals that the state has to be written, see EnableState
 	mu       sync.Mutex
 }
 
 func NewManager() *Manager {
 	return &Manager{
 		services: make(map[string]*service.Service),
+		events:   events.NewBus(eventBufferSize),
+		dirty:    make(chan struct{}, 1),
 	}
 }
 
-// Create a new identifier for a service
-func (m *Manager) RandomID(length int) string {
-	if length <= 0 {
-		return ""
-	}
-	var sb strings.Builder
-	sb.Grow(length)
-	for i := 0; i < length; i++ {
-		randomChar := charset[rand.Intn(len(charset))]
-		sb.WriteByte(randomChar)
-	}
-
-	for _, service := range m.services {
-		if service.ID == sb.String() {
-			return m.RandomID(length)
-		}
-	}
+// Events returns the event stream of the services
+func (m *Manager) Events() *events.Bus {
+	return m.events
+}
 
-	return sb.String()
+func (m *Manager) publish(service *service.Service, kind events.Kind, detail string) {
+	m.events.Publish(kind, service.ID, service.GetPID(), detail)
+	m.saveState()
 }
 
 func (m *Manager) RunAutostart() {
 	applications := config.GetConfig().Autostart
-	for _, app := range applications {
-		id := m.RandomID(10)
-		m.AddService(id, app, []string{}, []string{}, "/")
-		err := m.StartService(id)
+	for name, app := range applications {
+		if m.exists(name) {
+			// Restored from the state directory
+			continue
+		}
+		id, err := m.AddServiceWithGeneratedID(name, service.Options{Binary: app, WorkingDir: "/"})
+		if err != nil {
+			log.Fatal("Failed to add service error: ", err)
+		}
+		err = m.StartService(id)
 		if err != nil {
 			log.Fatal("Failed to start service error: ", err)
 		}
 	}
+
+	// A restored target was already reached before the daemon restarted
+	if target := config.GetConfig().BootTarget(); target != "" && m.Target() == "" {
+		started, err := m.StartTarget(target)
+		if err != nil {
+			log.Fatalf("Failed to reach target %s: %v", target, err)
+		}
+		fmt.Printf("Reached target %s, started: %v\n", target, started)
+	}
 }
 
-func (m *Manager) AddService(id string, command string, args []string, env []string, workingDir string) error {
+// AddService registers a new service, it fails if the ID is already taken
+func (m *Manager) AddService(id string, opts service.Options) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	service, err := service.NewService(id, command, args, env, workingDir)
+	if _, exists := m.services[id]; exists {
+		return fmt.Errorf("service %s already exists, remove it first or use --replace", id)
+	}
+	return m.addService(id, opts)
+}
+
+// AddServiceWithGeneratedID registers a new service under a free ID derived
+// from base, like "firefox" or "firefox-2", and returns the ID
+func (m *Manager) AddServiceWithGeneratedID(base string, opts service.Options) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	id := m.generateID(base)
+	return id, m.addService(id, opts)
+}
+
+// ReplaceService stops and removes the service registered under id, if any,
+// before registering the new one. The new options are validated first so a
+// broken replacement keeps the old instance running.
+func (m *Manager) ReplaceService(id string, opts service.Options, timeout time.Duration) error {
+	replacement, err := service.NewService(id, opts)
 	if err != nil {
-		return fmt.Errorf("NewService returns error: %v", err)
+		return fmt.Errorf("invalid service %s: %v", id, err)
 	}
+	replacement.OnEvent = m.publish
+
+	if old, err := m.getService(id); err == nil {
+		if err := old.Stop(timeout); err != nil {
+			return fmt.Errorf("failed to stop the old instance of %s: %v", id, err)
+		}
+		old.Close()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.services[id] = replacement
+	m.saveState()
+	return nil
+}
+
+func (m *Manager) addService(id string, opts service.Options) error {
+	service, err := service.NewService(id, opts)
+
+	if err != nil {
+		return fmt.Errorf("invalid service %s: %v", id, err)
+	}
+	service.OnEvent = m.publish
 	m.services[id] = service
+	m.saveState()
 	return nil
 }
 
+// StartService starts the service and waits until it counts as started for
+// its type. The lock is not held while waiting so that a slow notify or
+// forking service does not block other requests.
 func (m *Manager) StartService(id string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	service, exists := m.services[id]
+	m.mu.Unlock()
 	if !exists {
 		return nil
 	}
 	return service.Start()
 }
 
+// SignalServiceWithID sends signal to the service, the lock is not held while
+// waiting for SIGTERM or SIGKILL to terminate the process
 func (m *Manager) SignalServiceWithID(id string, signal os.Signal) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	service, exists := m.services[id]
-	if !exists {
-		return nil
+	service, err := m.getService(id)
+	if err != nil {
+		return err
 	}
 	return service.SignalProcess(signal)
 }
 
 func (m *Manager) SignalServiceWithPID(pid int, signal os.Signal) error {
+	id := m.GetID(pid)
+	if id == "" {
+		return fmt.Errorf("no service with PID %d", pid)
+	}
+	return m.SignalServiceWithID(id, signal)
+}
+
+// StopService stops the service, sending SIGKILL if it is still running after
+// timeout. A zero timeout uses the stop_timeout of the service.
+func (m *Manager) StopService(id string, timeout time.Duration) error {
+	service, err := m.getService(id)
+	if err != nil {
+		return err
+	}
+	return service.Stop(timeout)
+}
+
+// RestartService stops the service if it is running and starts it again
+func (m *Manager) RestartService(id string, timeout time.Duration) error {
+	service, err := m.getService(id)
+	if err != nil {
+		return err
+	}
+	return service.Restart(timeout)
+}
+
+// TryRestartService restarts the service only if it is running
+func (m *Manager) TryRestartService(id string, timeout time.Duration) (bool, error) {
+	service, err := m.getService(id)
+	if err != nil {
+		return false, err
+	}
+	return service.TryRestart(timeout)
+}
+
+// ReloadService runs the reload hooks of the service
+func (m *Manager) ReloadService(id string) error {
+	service, err := m.getService(id)
+	if err != nil {
+		return err
+	}
+	return service.Reload()
+}
+
+// RemoveService stops the service and forgets it
+func (m *Manager) RemoveService(id string, timeout time.Duration) error {
+	service, err := m.getService(id)
+	if err != nil {
+		return err
+	}
+	if err := service.Stop(timeout); err != nil {
+		return err
+	}
+	service.Close()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
-
-	for _, service := range m.services {
-		if service.GetPID() == pid {
-			service.SignalProcess(signal)
-		}
+	if m.services[id] == service {
+		delete(m.services, id)
+		m.saveState()
 	}
 	return nil
 }
 
+func (m *Manager) getService(id string) (*service.Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	service, exists := m.services[id]
+	if !exists {
+		return nil, fmt.Errorf("no service with ID %s", id)
+	}
+	return service, nil
+}
+
 func (m *Manager) ServiceStatusByID(id string) string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -131,6 +246,92 @@ func (m *Manager) ServiceStatusByPID(pid int) string {
 	return ""
 }
 
+// ServiceSummary is one entry of the service list
+type ServiceSummary struct {
+	ID       string `json:"id"`
+	State    string `json:"state"`
+	PID      int    `json:"pid,omitempty"` // Only set while running
+	Health   string `json:"health"`
+	Restarts int    `json:"restarts"`
+}
+
+// List returns every service sorted by ID
+func (m *Manager) List() []ServiceSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]ServiceSummary, 0, len(m.services))
+	for id, service := range m.services {
+		summary := ServiceSummary{
+			ID:       id,
+			State:    service.State(),
+			Health:   service.Health(),
+			Restarts: service.RestartCount(),
+		}
+		if service.Process.Status() == "running" {
+			summary.PID = service.GetPID()
+		}
+		list = append(list, summary)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// ServiceOutput returns the captured output of the last start
+func (m *Manager) ServiceOutput(id string) (string, error) {
+	service, err := m.getService(id)
+	if err != nil {
+		return "", err
+	}
+	return service.Process.Output(), nil
+}
+
+// ServiceEnvironment returns the effective environment of the last start
+func (m *Manager) ServiceEnvironment(id string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	service, exists := m.services[id]
+	if !exists {
+		return []string{}
+	}
+	return service.Process.Environment()
+}
+
+// ServiceNamespaces returns the namespaces of a service, nil if it has none
+func (m *Manager) ServiceNamespaces(id string) map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	service, exists := m.services[id]
+	if !exists {
+		return nil
+	}
+	return service.Namespaces()
+}
+
+// ServiceCapabilities returns the capability sets of a running service, nil
+// if it doesn't run
+func (m *Manager) ServiceCapabilities(id string) map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	service, exists := m.services[id]
+	if !exists {
+		return nil
+	}
+	return service.Capabilities()
+}
+
+// ServiceScheduling returns the scheduling values of a running service, nil
+// if it doesn't run
+func (m *Manager) ServiceScheduling(id string) map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	service, exists := m.services[id]
+	if !exists {
+		return nil
+	}
+	return service.Scheduling()
+}
+
 func (m *Manager) GetPID(id string) int {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -141,6 +342,48 @@ func (m *Manager) GetPID(id string) int {
 	return service.GetPID()
 }
 
+// StopAll stops every service at the same time, each with its stop_timeout,
+// and returns a line for each service that was running
+func (m *Manager) StopAll() []string {
+	m.mu.Lock()
+	services := make([]*service.Service, 0, len(m.services))
+	for _, service := range m.services {
+		services = append(services, service)
+	}
+	m.mu.Unlock()
+	sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+
+	results := make([]string, len(services))
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Stopping a service that isn't running cancels a pending restart
+			running := service.Process.Status() == "running"
+			if err := service.Stop(0); err != nil {
+				results[i] = fmt.Sprintf("failed to stop %s: %v", service.ID, err)
+			} else if running {
+				results[i] = "stopped " + service.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := []string{}
+	for _, result := range results {
+		if result != "" {
+			lines = append(lines, result)
+		}
+	}
+	return lines
+}
+
+// ReapOrphans reaps the zombies of reparented processes, call it on SIGCHLD
+func (m *Manager) ReapOrphans() {
+	service.ReapZombies()
+}
+
 func (m *Manager) GetID(pid int) string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
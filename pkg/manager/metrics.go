@@ -0,0 +1,56 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"ops-ctrl/pkg/metrics"
+	"ops-ctrl/pkg/procfs"
+)
+
+// ServiceMetrics returns a snapshot of every service for the metrics endpoint
+func (m *Manager) ServiceMetrics() []metrics.ServiceMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshots := make([]metrics.ServiceMetrics, 0, len(m.services))
+	for id, service := range m.services {
+		snapshot := metrics.ServiceMetrics{
+			ID:           id,
+			State:        service.State(),
+			Health:       service.Health(),
+			Restarts:     service.RestartCount(),
+			LastExitCode: service.Process.ExitCode(),
+		}
+
+		if service.Process.Status() == "running" {
+			snapshot.UptimeSeconds = time.Since(service.Process.StartedAt()).Seconds()
+			if stat, err := procfs.ReadStat(service.GetPID()); err == nil {
+				snapshot.ProcessRunning = true
+				snapshot.CPUSeconds = stat.CPUSeconds()
+				snapshot.RSSBytes = stat.RSSBytes()
+				snapshot.OpenFDs, _ = procfs.CountFDs(service.GetPID())
+			}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+// usageSampleInterval is how long the CPU time is sampled for the CPU percentage
+const usageSampleInterval = 250 * time.Millisecond
+
+// ServiceUsage samples the resource usage of a running service, with
+// descendants the usage of every process below the main PID is included
+func (m *Manager) ServiceUsage(id string, descendants bool) (procfs.Usage, error) {
+	m.mu.Lock()
+	service, exists := m.services[id]
+	m.mu.Unlock()
+	if !exists {
+		return procfs.Usage{}, fmt.Errorf("no service with ID %s", id)
+	}
+	if service.Process.Status() != "running" {
+		return procfs.Usage{}, fmt.Errorf("service %s is not running", id)
+	}
+	return procfs.ReadUsage(service.GetPID(), descendants, usageSampleInterval)
+}
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+var (
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomMu sync.Mutex
+)
+
+// Create a new identifier for a service
+func (m *Manager) RandomID(length int) string {
+	if length <= 0 {
+		return ""
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		var sb strings.Builder
+		sb.Grow(length)
+		randomMu.Lock()
+		for i := 0; i < length; i++ {
+			sb.WriteByte(charset[random.Intn(len(charset))])
+		}
+		randomMu.Unlock()
+
+		if _, exists := m.services[sb.String()]; !exists {
+			return sb.String()
+		}
+	}
+}
+
+var unsafeNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.@-]+`)
+
+// NameFromBinary derives a readable service name from a binary path,
+// "/usr/bin/firefox" becomes "firefox"
+func NameFromBinary(binary string) string {
+	name := unsafeNameCharacters.ReplaceAllString(filepath.Base(binary), "-")
+	name = strings.Trim(name, "-.")
+	if name == "" {
+		return "service"
+	}
+	return name
+}
+
+// generateID returns base if it's free, otherwise base-2, base-3 and so on.
+// The caller must hold m.mu.
+func (m *Manager) generateID(base string) string {
+	if _, exists := m.services[base]; !exists {
+		return base
+	}
+	for i := 2; ; i++ {
+		id := base + "-" + strconv.Itoa(i)
+		if _, exists := m.services[id]; !exists {
+			return id
+		}
+	}
+}
+
+// ResolveID finds a service by its full ID, a unique ID prefix or the unique
+// alias it was started from
+func (m *Manager) ResolveID(reference string) (string, error) {
+	if reference == "" {
+		return "", fmt.Errorf("empty service ID")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.services[reference]; exists {
+		return reference, nil
+	}
+
+	prefixed := []string{}
+	aliased := []string{}
+	for id, service := range m.services {
+		if strings.HasPrefix(id, reference) {
+			prefixed = append(prefixed, id)
+		}
+		if service.Options.Alias == reference {
+			aliased = append(aliased, id)
+		}
+	}
+
+	for _, matches := range [][]string{prefixed, aliased} {
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return matches[0], nil
+		default:
+			sort.Strings(matches)
+			return "", fmt.Errorf("%s is ambiguous, matches: %s", reference, strings.Join(matches, ", "))
+		}
+	}
+	return "", fmt.Errorf("no service with ID %s", reference)
+}
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+
+	"ops-ctrl/pkg/service"
+)
+
+// handover is the state a re-executed daemon continues with
+type handover struct {
+	ControlFD int `json:"control_fd"` // Listening control socket
+	managerState
+}
+
+// Handover writes the state for a re-executed daemon to path. The control
+// socket and the output pipes of the running services are kept open across
+// exec, they are referenced by their file descriptor. The output is no longer
+// copied from here on, the executed daemon reads what the services write in
+// the meantime. CancelHandover undoes it if exec fails.
+func (m *Manager) Handover(path string, control *os.File) error {
+	err := m.handover(path, control)
+	if err != nil {
+		m.CancelHandover(control)
+	}
+	return err
+}
+
+func (m *Manager) handover(path string, control *os.File) error {
+	controlFD, err := descriptor(control)
+	if err != nil {
+		return err
+	}
+	if err := inherit(controlFD); err != nil {
+		return err
+	}
+
+	// Paused before the state is taken, output read after it would be lost
+	outputs := make(map[string]*os.File)
+	for _, service := range m.serviceList() {
+		output, err := service.Process.PauseOutput()
+		if err != nil {
+			return fmt.Errorf("service %s: %v", service.ID, err)
+		}
+		if output != nil {
+			outputs[service.ID] = output
+		}
+	}
+
+	state := handover{ControlFD: controlFD, managerState: m.snapshot()}
+	for i, snapshot := range state.Services {
+		output := outputs[snapshot.ID]
+		if output == nil || snapshot.PID == 0 {
+			continue
+		}
+		fd, err := descriptor(output)
+		if err != nil {
+			return err
+		}
+		if err := inherit(fd); err != nil {
+			return err
+		}
+		state.Services[i].OutputFD = fd
+	}
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	return nil
+}
+
+// CancelHandover continues after a failed Handover or exec. The control
+// socket and the output pipes are closed on exec again and the output is
+// copied again.
+func (m *Manager) CancelHandover(control *os.File) {
+	if fd, err := descriptor(control); err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	for _, service := range m.serviceList() {
+		if output := service.Process.OutputPipe(); output != nil {
+			if fd, err := descriptor(output); err == nil {
+				syscall.CloseOnExec(fd)
+			}
+		}
+		service.Process.ResumeOutput()
+	}
+}
+
+// serviceList returns every registered service
+func (m *Manager) serviceList() []*service.Service {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	services := make([]*service.Service, 0, len(m.services))
+	for _, service := range m.services {
+		services = append(services, service)
+	}
+	return services
+}
+
+// TakeOver continues with the state a daemon handed over before executing
+// this one and returns its control socket. The handover file is removed.
+func (m *Manager) TakeOver(path string) (*os.File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handover: %v", err)
+	}
+	os.Remove(path)
+
+	var state handover
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("invalid handover: %v", err)
+	}
+	syscall.CloseOnExec(state.ControlFD)
+	control := os.NewFile(uintptr(state.ControlFD), "control socket")
+
+	m.restoreState(state.managerState)
+	return control, nil
+}
+
+// descriptor returns the file descriptor of file. Unlike File.Fd it keeps the
+// file non-blocking, so its read deadline still works.
+func descriptor(file *os.File) (int, error) {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get file descriptor: %v", err)
+	}
+	fd := -1
+	if err := conn.Control(func(raw uintptr) { fd = int(raw) }); err != nil {
+		return 0, fmt.Errorf("failed to get file descriptor: %v", err)
+	}
+	return fd, nil
+}
+
+// inherit clears close-on-exec so fd stays open in the executed binary
+func inherit(fd int) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETFD, 0); errno != 0 {
+		return fmt.Errorf("failed to keep file descriptor %d open: %v", fd, errno)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"ops-ctrl/pkg/events"
+	"ops-ctrl/pkg/securedir"
+	"ops-ctrl/pkg/service"
+)
+
+// stateFile is the file in the state directory that holds the services
+const stateFile = "services.json"
+
+// managerState is written to the state directory after every change
+type managerState struct {
+	Target   string             `json:"target,omitempty"`
+	Services []service.Snapshot `json:"services"`
+}
+
+// EnableState writes the state of every service to dir from now on. dir is
+// created with mode 0700, an existing one must belong to the daemon's user.
+func (m *Manager) EnableState(dir string) error {
+	if err := securedir.Create(dir, 0700); err != nil {
+		return fmt.Errorf("invalid state directory: %v", err)
+	}
+	go m.writeState(filepath.Join(dir, stateFile))
+	m.saveState()
+	return nil
+}
+
+// saveState asks for the state to be written. It doesn't block, so it can be
+// called with the lock held.
+func (m *Manager) saveState() {
+	select {
+	case m.dirty <- struct{}{}:
+	default:
+		// A write is already pending and will include this change
+	}
+}
+
+// writeState writes the latest state whenever it changed, replacing the file
+// atomically so a crash leaves either the old or the new state
+func (m *Manager) writeState(path string) {
+	for range m.dirty {
+		content, err := json.MarshalIndent(m.snapshot(), "", "  ")
+		if err != nil {
+			fmt.Printf("Failed to encode state: %v\n", err)
+			continue
+		}
+		temporary := path + ".tmp"
+		if err := os.WriteFile(temporary, content, 0600); err != nil {
+			fmt.Printf("Failed to write state: %v\n", err)
+			continue
+		}
+		if err := os.Rename(temporary, path); err != nil {
+			fmt.Printf("Failed to write state: %v\n", err)
+		}
+	}
+}
+
+func (m *Manager) snapshot() managerState {
+	m.mu.Lock()
+	state := managerState{Target: m.target}
+	services := make([]*service.Service, 0, len(m.services))
+	for _, service := range m.services {
+		services = append(services, service)
+	}
+	m.mu.Unlock()
+
+	state.Services = make([]service.Snapshot, 0, len(services))
+	for _, service := range services {
+		state.Services = append(state.Services, service.Snapshot())
+	}
+	sort.Slice(state.Services, func(i, j int) bool { return state.Services[i].ID < state.Services[j].ID })
+	return state
+}
+
+// Restore registers the services of the state in dir and adopts the
+// processes that are still running. Lost processes are started again if
+// their restart policy asks for it. A missing state file is not an error.
+//
+// The state names binaries that are run as the daemon's user, so dir and the
+// file are refused if another user owns them or may write to them.
+func (m *Manager) Restore(dir string) error {
+	path := filepath.Join(dir, stateFile)
+	for _, checked := range []string{dir, path} {
+		if err := securedir.Check(checked); errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("untrusted state: %v", err)
+		}
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read state: %v", err)
+	}
+	var state managerState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return fmt.Errorf("invalid state file: %v", err)
+	}
+
+	m.restoreState(state)
+	return nil
+}
+
+// restoreState registers the services of state, see Restore
+func (m *Manager) restoreState(state managerState) {
+	m.mu.Lock()
+	m.target = state.Target
+	m.mu.Unlock()
+
+	for _, snapshot := range state.Services {
+		restored, lost, err := service.RestoreService(snapshot, m.publish)
+		if err != nil {
+			fmt.Printf("Failed to restore service %s: %v\n", snapshot.ID, err)
+			if snapshot.OutputFD > 0 {
+				syscall.Close(snapshot.OutputFD)
+			}
+			continue
+		}
+		m.mu.Lock()
+		m.services[snapshot.ID] = restored
+		m.mu.Unlock()
+
+		if !lost {
+			if restored.State() == "running" {
+				fmt.Printf("Adopted service %s with PID %d\n", snapshot.ID, restored.GetPID())
+			}
+			continue
+		}
+		m.publish(restored, events.Failed, "process lost while the daemon was not running")
+		if restored.Options.Restart != service.RestartNo {
+			if err := restored.Start(); err != nil {
+				fmt.Printf("Failed to start lost service %s: %v\n", snapshot.ID, err)
+			}
+		}
+	}
+	m.saveState()
+}
@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"fmt"
+
+	"ops-ctrl/pkg/config"
+)
+
+// StartTarget starts the services of the target and the targets it requires.
+// Services started by a target are named after their definition.
+func (m *Manager) StartTarget(name string) ([]string, error) {
+	cfg := config.GetConfig()
+	services, err := cfg.TargetServices(name)
+	if err != nil {
+		return nil, err
+	}
+
+	started := []string{}
+	for _, name := range services {
+		if m.isRunning(name) {
+			continue
+		}
+		if !m.exists(name) {
+			definition, exists := cfg.ServiceDefinition(name)
+			if !exists {
+				return started, fmt.Errorf("unknown service definition: %s", name)
+			}
+			definition.Alias = name
+			if err := m.AddService(name, definition); err != nil {
+				return started, fmt.Errorf("failed to add service %s: %v", name, err)
+			}
+		}
+		if err := m.StartService(name); err != nil {
+			return started, fmt.Errorf("failed to start service %s: %v", name, err)
+		}
+		started = append(started, name)
+	}
+
+	m.mu.Lock()
+	m.target = name
+	m.saveState()
+	m.mu.Unlock()
+	return started, nil
+}
+
+// Isolate switches to the target, starting what it needs and stopping every
+// other service that is still active, a pending restart is canceled too
+func (m *Manager) Isolate(name string) (started []string, stopped []string, err error) {
+	services, err := config.GetConfig().TargetServices(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, service := range services {
+		wanted[service] = true
+	}
+
+	m.mu.Lock()
+	unwanted := []string{}
+	for id, service := range m.services {
+		if wanted[id] {
+			continue
+		}
+		switch service.State() {
+		case "running", "starting", "stopping", "restarting":
+			unwanted = append(unwanted, id)
+		}
+	}
+	m.mu.Unlock()
+
+	stopped = []string{}
+	for _, id := range unwanted {
+		if err := m.StopService(id, 0); err != nil {
+			return nil, stopped, fmt.Errorf("failed to stop service %s: %v", id, err)
+		}
+		stopped = append(stopped, id)
+	}
+
+	started, err = m.StartTarget(name)
+	return started, stopped, err
+}
+
+// Target returns the last target that was reached
+func (m *Manager) Target() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.target
+}
+
+func (m *Manager) exists(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, exists := m.services[id]
+	return exists
+}
+
+func (m *Manager) isRunning(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	service, exists := m.services[id]
+	return exists && service.State() == "running"
+}
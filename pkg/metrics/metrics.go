@@ -0,0 +1,206 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceMetrics is a snapshot of one service
+type ServiceMetrics struct {
+	ID             string
+	State          string
+	Health         string
+	UptimeSeconds  float64 // 0 when not running
+	Restarts       int
+	LastExitCode   int
+	CPUSeconds     float64 // Only set when running
+	RSSBytes       int64
+	OpenFDs        int
+	ProcessRunning bool // The /proc based values are only set while running
+}
+
+// Collector provides the service snapshots, implemented by the manager
+type Collector interface {
+	ServiceMetrics() []ServiceMetrics
+}
+
+// Latency buckets of the control requests in seconds
+var requestBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+
+type requestKey struct {
+	action string
+	result string
+}
+
+type histogram struct {
+	buckets []uint64 // Cumulative counts per requestBuckets entry
+	count   uint64
+	sum     float64
+}
+
+var (
+	requests  = make(map[requestKey]uint64)
+	latencies = make(map[string]*histogram)
+	startTime = time.Now()
+	mu        sync.Mutex
+)
+
+// ObserveRequest records a handled control request
+func ObserveRequest(action string, result string, duration time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	requests[requestKey{action, result}]++
+
+	latency, exists := latencies[action]
+	if !exists {
+		latency = &histogram{buckets: make([]uint64, len(requestBuckets))}
+		latencies[action] = latency
+	}
+	seconds := duration.Seconds()
+	for i, bound := range requestBuckets {
+		if seconds <= bound {
+			latency.buckets[i]++
+		}
+	}
+	latency.count++
+	latency.sum += seconds
+}
+
+// Serve exposes the metrics on listen, "unix:///path" or "tcp://host:port".
+// It returns once the listener is up.
+func Serve(listen string, collector Collector) error {
+	network, address, found := strings.Cut(listen, "://")
+	if !found {
+		network, address = "tcp", listen
+	}
+	if network == "unix" {
+		os.Remove(address)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", listen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, collector)
+	})
+	go http.Serve(listener, mux)
+	return nil
+}
+
+// Write writes every metric in the Prometheus text format
+func Write(w io.Writer, collector Collector) {
+	services := collector.ServiceMetrics()
+	sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+
+	header(w, "ops_ctrl_service_state", "gauge", "Current state of the service, 1 for the current state")
+	for _, service := range services {
+		fmt.Fprintf(w, "ops_ctrl_service_state{service=%s,state=%s} 1\n", quote(service.ID), quote(service.State))
+	}
+
+	header(w, "ops_ctrl_service_health", "gauge", "Health of the service, 1 for the current health")
+	for _, service := range services {
+		fmt.Fprintf(w, "ops_ctrl_service_health{service=%s,health=%s} 1\n", quote(service.ID), quote(service.Health))
+	}
+
+	header(w, "ops_ctrl_service_uptime_seconds", "gauge", "Seconds since the current process was started, 0 when not running")
+	for _, service := range services {
+		fmt.Fprintf(w, "ops_ctrl_service_uptime_seconds{service=%s} %g\n", quote(service.ID), service.UptimeSeconds)
+	}
+
+	header(w, "ops_ctrl_service_restarts_total", "counter", "Automatic restarts by the restart policy")
+	for _, service := range services {
+		fmt.Fprintf(w, "ops_ctrl_service_restarts_total{service=%s} %d\n", quote(service.ID), service.Restarts)
+	}
+
+	header(w, "ops_ctrl_service_last_exit_code", "gauge", "Exit code of the last process, -1 if killed by a signal")
+	for _, service := range services {
+		fmt.Fprintf(w, "ops_ctrl_service_last_exit_code{service=%s} %d\n", quote(service.ID), service.LastExitCode)
+	}
+
+	header(w, "ops_ctrl_service_cpu_seconds_total", "counter", "User and system CPU time of the main process")
+	for _, service := range services {
+		if service.ProcessRunning {
+			fmt.Fprintf(w, "ops_ctrl_service_cpu_seconds_total{service=%s} %g\n", quote(service.ID), service.CPUSeconds)
+		}
+	}
+
+	header(w, "ops_ctrl_service_resident_memory_bytes", "gauge", "Resident set size of the main process")
+	for _, service := range services {
+		if service.ProcessRunning {
+			fmt.Fprintf(w, "ops_ctrl_service_resident_memory_bytes{service=%s} %d\n", quote(service.ID), service.RSSBytes)
+		}
+	}
+
+	header(w, "ops_ctrl_service_open_fds", "gauge", "Open file descriptors of the main process")
+	for _, service := range services {
+		if service.ProcessRunning {
+			fmt.Fprintf(w, "ops_ctrl_service_open_fds{service=%s} %d\n", quote(service.ID), service.OpenFDs)
+		}
+	}
+
+	writeDaemonMetrics(w, len(services))
+}
+
+func writeDaemonMetrics(w io.Writer, serviceCount int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	header(w, "ops_ctrl_services", "gauge", "Registered services")
+	fmt.Fprintf(w, "ops_ctrl_services %d\n", serviceCount)
+
+	header(w, "ops_ctrl_start_time_seconds", "gauge", "Start time of the daemon since the Unix epoch")
+	fmt.Fprintf(w, "ops_ctrl_start_time_seconds %d\n", startTime.Unix())
+
+	keys := make([]requestKey, 0, len(requests))
+	for key := range requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].action != keys[j].action {
+			return keys[i].action < keys[j].action
+		}
+		return keys[i].result < keys[j].result
+	})
+	header(w, "ops_ctrl_control_requests_total", "counter", "Control requests by action and result")
+	for _, key := range keys {
+		fmt.Fprintf(w, "ops_ctrl_control_requests_total{action=%s,result=%s} %d\n", quote(key.action), quote(key.result), requests[key])
+	}
+
+	actions := make([]string, 0, len(latencies))
+	for action := range latencies {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	header(w, "ops_ctrl_control_request_duration_seconds", "histogram", "Time to handle control requests")
+	for _, action := range actions {
+		latency := latencies[action]
+		for i, bound := range requestBuckets {
+			fmt.Fprintf(w, "ops_ctrl_control_request_duration_seconds_bucket{action=%s,le=\"%g\"} %d\n", quote(action), bound, latency.buckets[i])
+		}
+		fmt.Fprintf(w, "ops_ctrl_control_request_duration_seconds_bucket{action=%s,le=\"+Inf\"} %d\n", quote(action), latency.count)
+		fmt.Fprintf(w, "ops_ctrl_control_request_duration_seconds_sum{action=%s} %g\n", quote(action), latency.sum)
+		fmt.Fprintf(w, "ops_ctrl_control_request_duration_seconds_count{action=%s} %d\n", quote(action), latency.count)
+	}
+}
+
+func header(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote formats a label value
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
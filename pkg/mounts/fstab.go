@@ -0,0 +1,100 @@
+package mounts
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultFstab is parsed when the configuration names no other file
+const DefaultFstab = "/etc/fstab"
+
+// Entry is a filesystem from fstab or the configuration
+type Entry struct {
+	Source  string `json:"source"`  // Device, file, directory of a bind mount or a name like "tmpfs"
+	Target  string `json:"target"`  // Mount point
+	FSType  string `json:"fstype"`  // Like ext4, tmpfs or "none" for bind mounts
+	Options string `json:"options"` // Comma separated like in fstab(5)
+	Origin  string `json:"origin"`  // fstab path or "config"
+}
+
+// sourceTags are the fstab(5) source forms resolved through /dev/disk
+var sourceTags = map[string]string{
+	"UUID":      "/dev/disk/by-uuid",
+	"LABEL":     "/dev/disk/by-label",
+	"PARTUUID":  "/dev/disk/by-partuuid",
+	"PARTLABEL": "/dev/disk/by-partlabel",
+}
+
+// ParseFstab reads the entries of an fstab(5) file. Swap and entries without
+// a mount point are left out.
+func ParseFstab(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected at least source, mount point and type", path, number)
+		}
+		entry := Entry{
+			Source:  resolveSource(unescape(fields[0])),
+			Target:  filepath.Clean(unescape(fields[1])),
+			FSType:  fields[2],
+			Options: "defaults",
+			Origin:  path,
+		}
+		if len(fields) > 3 {
+			entry.Options = fields[3]
+		}
+		if entry.FSType == "swap" || fields[1] == "none" {
+			continue
+		}
+		if !filepath.IsAbs(entry.Target) {
+			return nil, fmt.Errorf("%s:%d: mount point must be an absolute path: %s", path, number, fields[1])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// resolveSource turns "UUID=..." and the other tags into device paths
+func resolveSource(source string) string {
+	tag, value, found := strings.Cut(source, "=")
+	if directory, known := sourceTags[tag]; found && known {
+		return filepath.Join(directory, strings.Trim(value, `"`))
+	}
+	return source
+}
+
+// unescape decodes the octal escapes like "\040" for a space of fstab(5)
+// and /proc/self/mountinfo
+func unescape(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var builder strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if value, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				builder.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		builder.WriteByte(field[i])
+	}
+	return builder.String()
+}
@@ -0,0 +1,110 @@
+package mounts
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFstab(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Entry
+		wantErr string
+	}{
+		{
+			name: "comments, swap and none are left out",
+			content: `# <file system> <mount point> <type> <options> <dump> <pass>
+/dev/sda1 / ext4 errors=remount-ro 0 1
+
+  # indented comment
+/dev/sda2 none swap sw 0 0
+tmpfs /tmp tmpfs nosuid,nodev 0 0
+/swapfile swap swap defaults 0 0
+`,
+			want: []Entry{
+				{Source: "/dev/sda1", Target: "/", FSType: "ext4", Options: "errors=remount-ro"},
+				{Source: "tmpfs", Target: "/tmp", FSType: "tmpfs", Options: "nosuid,nodev"},
+			},
+		},
+		{
+			name:    "options default to defaults",
+			content: "proc /proc proc\n",
+			want:    []Entry{{Source: "proc", Target: "/proc", FSType: "proc", Options: "defaults"}},
+		},
+		{
+			name:    "tags are resolved through /dev/disk",
+			content: "UUID=1234-abcd /boot vfat umask=0077\nLABEL=\"data\" /srv/data ext4 noauto\nPARTUUID=0a /a xfs\nPARTLABEL=b /b xfs\n",
+			want: []Entry{
+				{Source: "/dev/disk/by-uuid/1234-abcd", Target: "/boot", FSType: "vfat", Options: "umask=0077"},
+				{Source: "/dev/disk/by-label/data", Target: "/srv/data", FSType: "ext4", Options: "noauto"},
+				{Source: "/dev/disk/by-partuuid/0a", Target: "/a", FSType: "xfs", Options: "defaults"},
+				{Source: "/dev/disk/by-partlabel/b", Target: "/b", FSType: "xfs", Options: "defaults"},
+			},
+		},
+		{
+			name:    "octal escapes and clean targets",
+			content: `/srv/my\040files /mnt/my\040files/ none bind 0 0` + "\n",
+			want:    []Entry{{Source: "/srv/my files", Target: "/mnt/my files", FSType: "none", Options: "bind"}},
+		},
+		{
+			name:    "too few fields",
+			content: "/dev/sda1 /\n",
+			wantErr: ":1: expected at least source, mount point and type",
+		},
+		{
+			name:    "relative mount point",
+			content: "# comment\ntmpfs tmp tmpfs defaults\n",
+			wantErr: ":2: mount point must be an absolute path: tmp",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "fstab")
+			if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseFstab(path)
+			if test.wantErr != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), test.wantErr) {
+					t.Fatalf("ParseFstab() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFstab() error = %v", err)
+			}
+			for i := range test.want {
+				test.want[i].Origin = path
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseFstab() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{`/plain`, `/plain`},
+		{`/a\040b`, `/a b`},
+		{`/tab\011end`, "/tab\tend"},
+		{`/back\134slash`, `/back\slash`},
+		{`/short\04`, `/short\04`},
+		{`/not\089octal`, `/not\089octal`},
+		{`/over\777`, `/over\777`},
+	}
+
+	for _, test := range tests {
+		if got := unescape(test.field); got != test.want {
+			t.Errorf("unescape(%q) = %q, want %q", test.field, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package mounts
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl requests and flags of linux/loop.h
+const (
+	loopCtlGetFree   = 0x4c82
+	loopSetFD        = 0x4c00
+	loopClrFD        = 0x4c01
+	loopSetStatus64  = 0x4c04
+	loFlagsAutoclear = 4
+	loFlagsReadOnly  = 1
+)
+
+// loopInfo64 is struct loop_info64
+type loopInfo64 struct {
+	device         uint64
+	inode          uint64
+	rdevice        uint64
+	offset         uint64
+	sizeLimit      uint64
+	number         uint32
+	encryptType    uint32
+	encryptKeySize uint32
+	flags          uint32
+	fileName       [64]byte
+	cryptName      [64]byte
+	encryptKey     [32]byte
+	init           [2]uint64
+}
+
+// attachLoop attaches the file to a free loop device and returns it open.
+// The device detaches itself once it is closed and unmounted.
+func attachLoop(path string, readOnly bool) (*os.File, error) {
+	mode := os.O_RDWR
+	if readOnly {
+		mode = os.O_RDONLY
+	}
+	backing, err := os.OpenFile(path, mode, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, unwrapPathError(err))
+	}
+	defer backing.Close()
+	control, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open loop control: %v", unwrapPathError(err))
+	}
+	defer control.Close()
+
+	// Another process may take the free device first
+	for attempt := 0; attempt < 5; attempt++ {
+		number, _, errno := syscall.Syscall(syscall.SYS_IOCTL, control.Fd(), loopCtlGetFree, 0)
+		if errno != 0 {
+			return nil, fmt.Errorf("failed to find a free loop device: %v", errno)
+		}
+		device, err := os.OpenFile(fmt.Sprintf("/dev/loop%d", number), mode, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open loop device: %v", unwrapPathError(err))
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopSetFD, backing.Fd()); errno != 0 {
+			device.Close()
+			if errno == syscall.EBUSY {
+				continue
+			}
+			return nil, fmt.Errorf("failed to attach %s to %s: %v", path, device.Name(), errno)
+		}
+
+		info := loopInfo64{flags: loFlagsAutoclear}
+		if readOnly {
+			info.flags |= loFlagsReadOnly
+		}
+		copy(info.fileName[:len(info.fileName)-1], path)
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopSetStatus64, uintptr(unsafe.Pointer(&info))); errno != 0 {
+			syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopClrFD, 0)
+			device.Close()
+			return nil, fmt.Errorf("failed to configure %s: %v", device.Name(), errno)
+		}
+		return device, nil
+	}
+	return nil, fmt.Errorf("failed to attach %s: every free loop device was taken", path)
+}
+
+func unwrapPathError(err error) error {
+	var pathError *os.PathError
+	if errors.As(err, &pathError) {
+		return pathError.Err
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package mounts
+
+import (
+	"strings"
+	"syscall"
+)
+
+// mountOptions are the fstab options split into what mount(2) takes and
+// what the daemon handles itself
+type mountOptions struct {
+	flags  uintptr
+	data   string // Options passed on to the filesystem
+	noAuto bool   // Only mounted when a service requires it
+	noFail bool   // A failure doesn't fail the boot step
+	loop   bool   // Source is a file attached to a loop device
+}
+
+// flagOptions set (true) or clear (false) mount flags
+var flagOptions = map[string]struct {
+	flag uintptr
+	set  bool
+}{
+	"ro":          {syscall.MS_RDONLY, true},
+	"rw":          {syscall.MS_RDONLY, false},
+	"nosuid":      {syscall.MS_NOSUID, true},
+	"suid":        {syscall.MS_NOSUID, false},
+	"nodev":       {syscall.MS_NODEV, true},
+	"dev":         {syscall.MS_NODEV, false},
+	"noexec":      {syscall.MS_NOEXEC, true},
+	"exec":        {syscall.MS_NOEXEC, false},
+	"sync":        {syscall.MS_SYNCHRONOUS, true},
+	"async":       {syscall.MS_SYNCHRONOUS, false},
+	"dirsync":     {syscall.MS_DIRSYNC, true},
+	"noatime":     {syscall.MS_NOATIME, true},
+	"atime":       {syscall.MS_NOATIME, false},
+	"nodiratime":  {syscall.MS_NODIRATIME, true},
+	"diratime":    {syscall.MS_NODIRATIME, false},
+	"relatime":    {syscall.MS_RELATIME, true},
+	"norelatime":  {syscall.MS_RELATIME, false},
+	"strictatime": {syscall.MS_STRICTATIME, true},
+	"bind":        {syscall.MS_BIND, true},
+	"rbind":       {syscall.MS_BIND | syscall.MS_REC, true},
+}
+
+// ignoredOptions only mean something to mount(8) or other tools
+var ignoredOptions = map[string]bool{
+	"defaults": true, "auto": true, "user": true, "nouser": true, "users": true,
+	"owner": true, "group": true, "_netdev": true,
+}
+
+func parseOptions(options string) mountOptions {
+	parsed := mountOptions{}
+	data := []string{}
+	for _, option := range strings.Split(options, ",") {
+		switch {
+		case option == "" || ignoredOptions[option] || strings.HasPrefix(option, "x-") || strings.HasPrefix(option, "comment="):
+		case option == "noauto":
+			parsed.noAuto = true
+		case option == "nofail":
+			parsed.noFail = true
+		case option == "loop" || strings.HasPrefix(option, "loop="):
+			parsed.loop = true
+		default:
+			if flag, isFlag := flagOptions[option]; isFlag {
+				if flag.set {
+					parsed.flags |= flag.flag
+				} else {
+					parsed.flags &^= flag.flag
+				}
+			} else {
+				data = append(data, option)
+			}
+		}
+	}
+	parsed.data = strings.Join(data, ",")
+	return parsed
+}
@@ -0,0 +1,35 @@
+package mounts
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		options string
+		want    mountOptions
+	}{
+		{options: "defaults", want: mountOptions{}},
+		{options: "", want: mountOptions{}},
+		{options: "ro,nosuid,nodev,noexec", want: mountOptions{flags: syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC}},
+		{options: "ro,rw", want: mountOptions{}},
+		{options: "rw,ro", want: mountOptions{flags: syscall.MS_RDONLY}},
+		{options: "noatime,atime,relatime", want: mountOptions{flags: syscall.MS_RELATIME}},
+		{options: "bind", want: mountOptions{flags: syscall.MS_BIND}},
+		{options: "rbind,ro", want: mountOptions{flags: syscall.MS_BIND | syscall.MS_REC | syscall.MS_RDONLY}},
+		{options: "size=64m,mode=1777,nosuid", want: mountOptions{flags: syscall.MS_NOSUID, data: "size=64m,mode=1777"}},
+		{options: "noauto,nofail", want: mountOptions{noAuto: true, noFail: true}},
+		{options: "loop,ro", want: mountOptions{flags: syscall.MS_RDONLY, loop: true}},
+		{options: "loop=/dev/loop3", want: mountOptions{loop: true}},
+		{options: "defaults,auto,user,nouser,users,owner,group,_netdev", want: mountOptions{}},
+		{options: "x-systemd.automount,comment=systemd.x,errors=remount-ro", want: mountOptions{data: "errors=remount-ro"}},
+		{options: "a,,b", want: mountOptions{data: "a,b"}},
+	}
+
+	for _, test := range tests {
+		if got := parseOptions(test.options); got != test.want {
+			t.Errorf("parseOptions(%q) = %+v, want %+v", test.options, got, test.want)
+		}
+	}
+}
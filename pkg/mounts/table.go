@@ -0,0 +1,336 @@
+package mounts
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// States of a mount
+const (
+	StateUnmounted = "unmounted"
+	StateMounted   = "mounted"
+	StateFailed    = "failed"
+)
+
+// OriginConfig marks the entries declared in the configuration
+const OriginConfig = "config"
+
+// Mount is a filesystem of the table, like a service it can be required and
+// has a state
+type Mount struct {
+	Entry
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`    // Why the last mount or unmount failed
+	External bool      `json:"external,omitempty"` // Was mounted before, it is left mounted at shutdown
+	Device   string    `json:"device,omitempty"`   // Loop device of a file source
+	Since    time.Time `json:"since"`              // Last state change
+	options  mountOptions
+	requires []*Mount // Mounts of parent directories of the target and of a path source
+}
+
+// Table holds the mounts in dependency order
+type Table struct {
+	mounts []*Mount
+	mu     sync.Mutex
+}
+
+// NewTable combines the entries of fstab with the declared ones, which
+// replace fstab entries of the same mount point. A missing DefaultFstab is
+// no error, fstab "-" reads no file.
+func NewTable(fstab string, declared []Entry) (*Table, error) {
+	entries := []Entry{}
+	if fstab == "" {
+		fstab = DefaultFstab
+	}
+	if fstab != "-" {
+		parsed, err := ParseFstab(fstab)
+		if err != nil && !(os.IsNotExist(err) && fstab == DefaultFstab) {
+			return nil, fmt.Errorf("failed to read fstab: %v", err)
+		}
+		entries = append(entries, parsed...)
+	}
+	for _, entry := range declared {
+		if !filepath.IsAbs(entry.Target) {
+			return nil, fmt.Errorf("mount point must be an absolute path: %s", entry.Target)
+		}
+		if entry.Options == "" {
+			entry.Options = "defaults"
+		}
+		entry.Target = filepath.Clean(entry.Target)
+		entry.Origin = OriginConfig
+		for i := range entries {
+			if entries[i].Target == entry.Target {
+				entries = append(entries[:i], entries[i+1:]...)
+				break
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	mounts := []*Mount{}
+	for _, entry := range entries {
+		mounts = append(mounts, &Mount{Entry: entry, State: StateUnmounted, Since: time.Now(), options: parseOptions(entry.Options)})
+	}
+	ordered, err := order(mounts)
+	if err != nil {
+		return nil, err
+	}
+	table := &Table{mounts: ordered}
+	table.refresh()
+	return table, nil
+}
+
+// order sorts the mounts so that each comes after the ones it requires,
+// otherwise keeping their order
+func order(mounts []*Mount) ([]*Mount, error) {
+	for _, m := range mounts {
+		for _, other := range mounts {
+			if other == m || other.Target == m.Target {
+				continue
+			}
+			if within(m.Target, other.Target) || (filepath.IsAbs(m.Source) && within(m.Source, other.Target)) {
+				m.requires = append(m.requires, other)
+			}
+		}
+	}
+
+	ordered := []*Mount{}
+	visiting := make(map[*Mount]bool)
+	visited := make(map[*Mount]bool)
+	var visit func(m *Mount) error
+	visit = func(m *Mount) error {
+		if visited[m] {
+			return nil
+		}
+		if visiting[m] {
+			return fmt.Errorf("mount dependency cycle at %s", m.Target)
+		}
+		visiting[m] = true
+		for _, required := range m.requires {
+			if err := visit(required); err != nil {
+				return err
+			}
+		}
+		visiting[m] = false
+		visited[m] = true
+		ordered = append(ordered, m)
+		return nil
+	}
+	for _, m := range mounts {
+		if err := visit(m); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// MountAll mounts every entry without noauto in dependency order. The error
+// lists the failed mounts that lack nofail.
+func (t *Table) MountAll() (mounted int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	failed := []string{}
+	attempts := make(map[*Mount]error)
+	for _, m := range t.mounts {
+		if m.options.noAuto || m.State == StateMounted {
+			continue
+		}
+		if err := t.mount(m, attempts); err != nil {
+			if !m.options.noFail {
+				failed = append(failed, err.Error())
+			}
+			continue
+		}
+		mounted++
+	}
+	if len(failed) > 0 {
+		return mounted, fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return mounted, nil
+}
+
+// Require mounts everything the paths are on, including noauto entries. It
+// is what requires_mounts_for of a service asks for.
+func (t *Table) Require(paths []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	attempts := make(map[*Mount]error)
+	for _, path := range paths {
+		for _, m := range t.mounts {
+			if !within(filepath.Clean(path), m.Target) {
+				continue
+			}
+			if err := t.mount(m, attempts); err != nil {
+				return fmt.Errorf("%s requires a mount: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// mount mounts m after the mounts it requires. attempts holds the results of
+// the mounts tried by the same call of MountAll or Require, so a failed mount
+// isn't tried again for every mount that requires it.
+func (t *Table) mount(m *Mount, attempts map[*Mount]error) error {
+	if m.State == StateMounted {
+		return nil
+	}
+	if err, attempted := attempts[m]; attempted {
+		return err
+	}
+	err := t.mountRequired(m, attempts)
+	attempts[m] = err
+	return err
+}
+
+func (t *Table) mountRequired(m *Mount, attempts map[*Mount]error) error {
+	for _, required := range m.requires {
+		if err := t.mount(required, attempts); err != nil {
+			return t.fail(m, fmt.Errorf("required %s is not mounted", required.Target))
+		}
+	}
+	if mountPoints()[m.Target] {
+		m.External = true
+		t.setState(m, StateMounted, "")
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Target, 0755); err != nil {
+		return t.fail(m, fmt.Errorf("failed to create %s: %v", m.Target, unwrapPathError(err)))
+	}
+	source := m.Source
+	if m.options.loop || (m.options.flags&syscall.MS_BIND == 0 && isRegularFile(source)) {
+		device, err := attachLoop(source, m.options.flags&syscall.MS_RDONLY != 0)
+		if err != nil {
+			return t.fail(m, err)
+		}
+		// The mount keeps the device attached, closing it detaches on unmount
+		defer device.Close()
+		source, m.Device = device.Name(), device.Name()
+	}
+
+	if err := syscall.Mount(source, m.Target, m.FSType, m.options.flags, m.options.data); err != nil {
+		return t.fail(m, fmt.Errorf("failed to mount %s on %s: %v", source, m.Target, err))
+	}
+	// A bind mount ignores the other flags until it is remounted
+	if m.options.flags&syscall.MS_BIND != 0 && m.options.flags&^(syscall.MS_BIND|syscall.MS_REC) != 0 {
+		flags := syscall.MS_REMOUNT | syscall.MS_BIND | m.options.flags&^syscall.MS_REC
+		if err := syscall.Mount("", m.Target, "", uintptr(flags), ""); err != nil {
+			syscall.Unmount(m.Target, 0)
+			return t.fail(m, fmt.Errorf("failed to apply the options of %s: %v", m.Target, err))
+		}
+	}
+	m.External = false
+	t.setState(m, StateMounted, "")
+	return nil
+}
+
+// UnmountAll unmounts what MountAll and Require mounted in reverse order and
+// returns a line for each. A busy filesystem is remounted read-only instead.
+func (t *Table) UnmountAll() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	results := []string{}
+	for i := len(t.mounts) - 1; i >= 0; i-- {
+		m := t.mounts[i]
+		if m.State != StateMounted || m.External {
+			continue
+		}
+		err := syscall.Unmount(m.Target, 0)
+		if err == nil {
+			m.Device = ""
+			t.setState(m, StateUnmounted, "")
+			results = append(results, "unmounted "+m.Target)
+			continue
+		}
+		detail := fmt.Sprintf("failed to unmount %s: %v", m.Target, err)
+		if remountErr := syscall.Mount("", m.Target, "", syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); remountErr == nil {
+			detail += ", remounted read-only"
+		}
+		m.Error = detail
+		results = append(results, detail)
+	}
+	return results
+}
+
+// List returns copies of the mounts in dependency order, with the state
+// updated for mounts that were mounted or unmounted by someone else
+func (t *Table) List() []Mount {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refresh()
+	list := []Mount{}
+	for _, m := range t.mounts {
+		list = append(list, *m)
+	}
+	return list
+}
+
+// refresh compares the states with /proc/self/mountinfo
+func (t *Table) refresh() {
+	points := mountPoints()
+	if points == nil {
+		return
+	}
+	for _, m := range t.mounts {
+		switch {
+		case points[m.Target] && m.State != StateMounted:
+			m.External = true
+			t.setState(m, StateMounted, "")
+		case !points[m.Target] && m.State == StateMounted:
+			m.External, m.Device = false, ""
+			t.setState(m, StateUnmounted, "")
+		}
+	}
+}
+
+func (t *Table) fail(m *Mount, err error) error {
+	t.setState(m, StateFailed, err.Error())
+	return err
+}
+
+func (t *Table) setState(m *Mount, state string, detail string) {
+	if m.State != state {
+		m.Since = time.Now()
+	}
+	m.State, m.Error = state, detail
+}
+
+// mountPoints returns the mount points of /proc/self/mountinfo, nil if it
+// can't be read
+func mountPoints() map[string]bool {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	points := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// The mount point is the fifth field
+		if fields := strings.Fields(scanner.Text()); len(fields) > 4 {
+			points[unescape(fields[4])] = true
+		}
+	}
+	return points
+}
+
+// within reports if path is dir or below it
+func within(path string, dir string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,128 @@
+package mounts
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// targets returns the mount points in table order
+func targets(mounts []*Mount) []string {
+	list := []string{}
+	for _, m := range mounts {
+		list = append(list, m.Target)
+	}
+	return list
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry // Source and Target
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "independent mounts keep their order",
+			entries: []Entry{{Source: "tmpfs", Target: "/b"}, {Source: "tmpfs", Target: "/a"}},
+			want:    []string{"/b", "/a"},
+		},
+		{
+			name:    "parents come first",
+			entries: []Entry{{Source: "tmpfs", Target: "/srv/data/cache"}, {Source: "/dev/sdb", Target: "/srv/data"}, {Source: "/dev/sda", Target: "/"}},
+			want:    []string{"/", "/srv/data", "/srv/data/cache"},
+		},
+		{
+			name:    "prefix of the name is no parent",
+			entries: []Entry{{Source: "tmpfs", Target: "/srv/database"}, {Source: "tmpfs", Target: "/srv/data"}},
+			want:    []string{"/srv/database", "/srv/data"},
+		},
+		{
+			name:    "bind source comes first",
+			entries: []Entry{{Source: "/srv/data/www", Target: "/var/www"}, {Source: "/dev/sdb", Target: "/srv/data"}},
+			want:    []string{"/srv/data", "/var/www"},
+		},
+		{
+			name:    "device sources need /dev",
+			entries: []Entry{{Source: "/dev/sdb", Target: "/srv"}, {Source: "devtmpfs", Target: "/dev"}, {Source: "proc", Target: "/proc"}},
+			want:    []string{"/dev", "/srv", "/proc"},
+		},
+		{
+			name:    "same mount point twice",
+			entries: []Entry{{Source: "tmpfs", Target: "/run"}, {Source: "tmpfs", Target: "/run"}},
+			want:    []string{"/run", "/run"},
+		},
+		{
+			name:    "cycle through bind sources",
+			entries: []Entry{{Source: "/b/x", Target: "/a"}, {Source: "/a/y", Target: "/b"}},
+			wantErr: "mount dependency cycle",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mounts := []*Mount{}
+			for _, entry := range test.entries {
+				mounts = append(mounts, &Mount{Entry: entry})
+			}
+			ordered, err := order(mounts)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("order() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("order() error = %v", err)
+			}
+			if got := targets(ordered); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("order() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewTableDeclaredEntries(t *testing.T) {
+	fstab := filepath.Join(t.TempDir(), "fstab")
+	content := "tmpfs /ops-ctrl-test/a tmpfs size=1m\ntmpfs /ops-ctrl-test/b tmpfs size=1m\n"
+	if err := os.WriteFile(fstab, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	declared := []Entry{
+		{Source: "tmpfs", Target: "/ops-ctrl-test/a/", FSType: "tmpfs", Options: "size=2m"},
+		{Source: "/dev/sdz", Target: "/ops-ctrl-test", FSType: "ext4"},
+	}
+
+	table, err := NewTable(fstab, declared)
+	if err != nil {
+		t.Fatalf("NewTable() error = %v", err)
+	}
+	if got, want := targets(table.mounts), []string{"/ops-ctrl-test", "/ops-ctrl-test/b", "/ops-ctrl-test/a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NewTable() order = %q, want %q", got, want)
+	}
+	for _, m := range table.mounts {
+		switch m.Target {
+		case "/ops-ctrl-test":
+			if m.Origin != OriginConfig || m.Options != "defaults" {
+				t.Errorf("declared entry = %+v, want origin config and options defaults", m.Entry)
+			}
+		case "/ops-ctrl-test/a":
+			if m.Origin != OriginConfig || m.options.data != "size=2m" {
+				t.Errorf("declared entry didn't replace the fstab entry: %+v", m.Entry)
+			}
+		case "/ops-ctrl-test/b":
+			if m.Origin != fstab {
+				t.Errorf("fstab entry origin = %s, want %s", m.Origin, fstab)
+			}
+		}
+	}
+
+	if _, err := NewTable("-", []Entry{{Source: "tmpfs", Target: "relative"}}); err == nil {
+		t.Error("NewTable() accepted a relative mount point")
+	}
+	if _, err := NewTable(filepath.Join(t.TempDir(), "missing"), nil); err == nil {
+		t.Error("NewTable() accepted a missing fstab other than the default")
+	}
+}
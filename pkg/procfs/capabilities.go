@@ -0,0 +1,36 @@
+package procfs
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Capabilities are the capability sets of a process as bit masks
+type Capabilities struct {
+	Inheritable uint64
+	Permitted   uint64
+	Effective   uint64
+	Bounding    uint64
+	Ambient     uint64
+}
+
+// ReadCapabilities parses the Cap* lines of /proc/<pid>/status
+func ReadCapabilities(pid int) (Capabilities, error) {
+	values, err := readKeyValues(fmt.Sprintf("/proc/%d/status", pid), ":")
+	if err != nil {
+		return Capabilities{}, err
+	}
+	capabilities := Capabilities{}
+	for key, mask := range map[string]*uint64{
+		"CapInh": &capabilities.Inheritable,
+		"CapPrm": &capabilities.Permitted,
+		"CapEff": &capabilities.Effective,
+		"CapBnd": &capabilities.Bounding,
+		"CapAmb": &capabilities.Ambient,
+	} {
+		if *mask, err = strconv.ParseUint(values[key], 16, 64); err != nil {
+			return Capabilities{}, fmt.Errorf("unexpected %s of %d: %q", key, pid, values[key])
+		}
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,65 @@
+package procfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Namespace returns the namespace of a process like "pid:[4026531836]", kind
+// is the name in /proc/<pid>/ns
+func Namespace(pid int, kind string) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/ns/%s", pid, kind))
+}
+
+// NamespacePIDs returns the PIDs of a process in the nested PID namespaces it
+// belongs to, from the namespace of the reader to its own (NSpid)
+func NamespacePIDs(pid int) ([]int, error) {
+	values, err := readKeyValues(fmt.Sprintf("/proc/%d/status", pid), ":")
+	if err != nil {
+		return nil, err
+	}
+	pids := []int{}
+	for _, field := range strings.Fields(values["NSpid"]) {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected NSpid of %d: %s", pid, values["NSpid"])
+		}
+		pids = append(pids, value)
+	}
+	return pids, nil
+}
+
+// HostPID translates PID nsPID in the PID namespace of process member to a
+// PID of the namespace of the reader
+func HostPID(nsPID int, member int) (int, error) {
+	namespace, err := Namespace(member, "pid")
+	if err != nil {
+		return 0, err
+	}
+	if own, err := Namespace(os.Getpid(), "pid"); err == nil && own == namespace {
+		if _, err := os.Stat(fmt.Sprintf("/proc/%d", nsPID)); err != nil {
+			return 0, fmt.Errorf("no process %d", nsPID)
+		}
+		return nsPID, nil
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if candidate, err := Namespace(pid, "pid"); err != nil || candidate != namespace {
+			continue
+		}
+		pids, err := NamespacePIDs(pid)
+		if err == nil && len(pids) > 0 && pids[len(pids)-1] == nsPID {
+			return pid, nil
+		}
+	}
+	return 0, fmt.Errorf("no process %d in %s", nsPID, namespace)
+}
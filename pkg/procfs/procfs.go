@@ -0,0 +1,86 @@
+package procfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ClockTicks is USER_HZ, the unit of the times in /proc/<pid>/stat. It's 100
+// on every architecture Linux supports.
+const ClockTicks = 100
+
+// Stat holds the fields of /proc/<pid>/stat that the daemon uses
+type Stat struct {
+	PID         int
+	Comm        string
+	State       string
+	PPID        int
+	UserTicks   uint64 // utime
+	SystemTicks uint64 // stime
+	NumThreads  int
+	StartTicks  uint64 // starttime, clock ticks after boot
+	RSSPages    int64
+	Nice        int
+	RTPriority  int // rt_priority, 0 for non-realtime policies
+	Policy      int // Scheduling policy of sched(7)
+}
+
+// ReadStat parses /proc/<pid>/stat
+func ReadStat(pid int) (Stat, error) {
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return Stat{}, err
+	}
+
+	// The command name can contain spaces and parentheses, the other fields
+	// start after the last ')'
+	start := strings.IndexByte(string(content), '(')
+	end := strings.LastIndexByte(string(content), ')')
+	if start < 0 || end < start {
+		return Stat{}, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(content[end+1:]))
+	// fields[0] is field 3 of proc(5)
+	if len(fields) < 22 {
+		return Stat{}, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+
+	stat := Stat{
+		PID:   pid,
+		Comm:  string(content[start+1 : end]),
+		State: fields[0],
+	}
+	stat.PPID, _ = strconv.Atoi(fields[1])
+	stat.UserTicks, _ = strconv.ParseUint(fields[11], 10, 64)
+	stat.SystemTicks, _ = strconv.ParseUint(fields[12], 10, 64)
+	stat.NumThreads, _ = strconv.Atoi(fields[17])
+	stat.StartTicks, _ = strconv.ParseUint(fields[19], 10, 64)
+	stat.RSSPages, _ = strconv.ParseInt(fields[21], 10, 64)
+	stat.Nice, _ = strconv.Atoi(fields[16])
+	if len(fields) > 38 {
+		stat.RTPriority, _ = strconv.Atoi(fields[37])
+		stat.Policy, _ = strconv.Atoi(fields[38])
+	}
+	return stat, nil
+}
+
+// CPUSeconds returns the user and system CPU time
+func (s Stat) CPUSeconds() float64 {
+	return float64(s.UserTicks+s.SystemTicks) / ClockTicks
+}
+
+// RSSBytes returns the resident set size
+func (s Stat) RSSBytes() int64 {
+	return s.RSSPages * int64(os.Getpagesize())
+}
+
+// CountFDs returns the number of open file descriptors
+func CountFDs(pid int) (int, error) {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
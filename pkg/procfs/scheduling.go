@@ -0,0 +1,36 @@
+package procfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Scheduling are the scheduling values of /proc/<pid>/status and
+// oom_score_adj that Stat lacks
+type Scheduling struct {
+	CPUs           string // Cpus_allowed_list, like "0-3,6"
+	Umask          string // Empty before Linux 4.7
+	OOMScoreAdjust int
+}
+
+// ReadScheduling reads the CPU affinity, umask and OOM score adjustment
+func ReadScheduling(pid int) (Scheduling, error) {
+	values, err := readKeyValues(fmt.Sprintf("/proc/%d/status", pid), ":")
+	if err != nil {
+		return Scheduling{}, err
+	}
+	scheduling := Scheduling{
+		CPUs:  values["Cpus_allowed_list"],
+		Umask: values["Umask"],
+	}
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/oom_score_adj", pid))
+	if err != nil {
+		return Scheduling{}, err
+	}
+	if scheduling.OOMScoreAdjust, err = strconv.Atoi(strings.TrimSpace(string(content))); err != nil {
+		return Scheduling{}, fmt.Errorf("unexpected oom_score_adj of %d: %q", pid, content)
+	}
+	return scheduling, nil
+}
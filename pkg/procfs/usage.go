@@ -0,0 +1,176 @@
+package procfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Usage is the resource usage of a process, optionally with its descendants
+type Usage struct {
+	PIDs       []int     `json:"pids"`        // Main PID first
+	CPUSeconds float64   `json:"cpu_seconds"` // User and system time
+	CPUPercent float64   `json:"cpu_percent"` // Over the sample interval, 100 is one core
+	RSSBytes   int64     `json:"rss_bytes"`
+	PSSBytes   int64     `json:"pss_bytes"` // 0 if smaps_rollup is not readable
+	Threads    int       `json:"threads"`
+	OpenFDs    int       `json:"open_fds"`
+	ReadBytes  uint64    `json:"read_bytes"`  // Storage I/O, 0 if /proc/<pid>/io is not readable
+	WriteBytes uint64    `json:"write_bytes"` // Storage I/O, 0 if /proc/<pid>/io is not readable
+	StartTime  time.Time `json:"start_time"`  // Start of the main PID
+}
+
+// ReadUsage samples the CPU time twice, interval apart, to calculate the CPU
+// percentage. With descendants the usage of every descendant is added.
+func ReadUsage(pid int, descendants bool, interval time.Duration) (Usage, error) {
+	pids := []int{pid}
+	if descendants {
+		pids = append(pids, Descendants(pid)...)
+	}
+
+	first, err := ReadStat(pid)
+	if err != nil {
+		return Usage{}, fmt.Errorf("process %d: %v", pid, err)
+	}
+	before := cpuTicks(pids)
+	sampled := time.Now()
+	time.Sleep(interval)
+
+	usage := Usage{PIDs: pids}
+	ticks := uint64(0)
+	for _, current := range pids {
+		stat, err := ReadStat(current)
+		if err != nil {
+			// Exited during the sample
+			continue
+		}
+		ticks += stat.UserTicks + stat.SystemTicks
+		usage.RSSBytes += stat.RSSBytes()
+		usage.Threads += stat.NumThreads
+		if fds, err := CountFDs(current); err == nil {
+			usage.OpenFDs += fds
+		}
+		if pss, err := ReadPSS(current); err == nil {
+			usage.PSSBytes += pss
+		}
+		if read, write, err := ReadIO(current); err == nil {
+			usage.ReadBytes += read
+			usage.WriteBytes += write
+		}
+	}
+
+	usage.CPUSeconds = float64(ticks) / ClockTicks
+	if elapsed := time.Since(sampled).Seconds(); elapsed > 0 && ticks >= before {
+		usage.CPUPercent = float64(ticks-before) / ClockTicks / elapsed * 100
+	}
+	if start, err := first.StartTime(); err == nil {
+		usage.StartTime = start
+	}
+	return usage, nil
+}
+
+func cpuTicks(pids []int) uint64 {
+	ticks := uint64(0)
+	for _, pid := range pids {
+		if stat, err := ReadStat(pid); err == nil {
+			ticks += stat.UserTicks + stat.SystemTicks
+		}
+	}
+	return ticks
+}
+
+// Descendants returns every process below pid
+func Descendants(pid int) []int {
+	children := make(map[int][]int)
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	for _, path := range stats {
+		current, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil {
+			continue
+		}
+		stat, err := ReadStat(current)
+		if err != nil {
+			continue
+		}
+		children[stat.PPID] = append(children[stat.PPID], current)
+	}
+
+	descendants := []int{}
+	queue := children[pid]
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		descendants = append(descendants, current)
+		queue = append(queue, children[current]...)
+	}
+	return descendants
+}
+
+// ReadPSS returns the proportional set size from /proc/<pid>/smaps_rollup
+func ReadPSS(pid int) (int64, error) {
+	values, err := readKeyValues(fmt.Sprintf("/proc/%d/smaps_rollup", pid), ":")
+	if err != nil {
+		return 0, err
+	}
+	kilobytes, err := strconv.ParseInt(strings.TrimSuffix(values["Pss"], " kB"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("no Pss in smaps_rollup")
+	}
+	return kilobytes * 1024, nil
+}
+
+// ReadIO returns the bytes read from and written to storage
+func ReadIO(pid int) (read uint64, write uint64, err error) {
+	values, err := readKeyValues(fmt.Sprintf("/proc/%d/io", pid), ":")
+	if err != nil {
+		return 0, 0, err
+	}
+	read, _ = strconv.ParseUint(values["read_bytes"], 10, 64)
+	write, _ = strconv.ParseUint(values["write_bytes"], 10, 64)
+	return read, write, nil
+}
+
+// BootTime returns the boot time from the btime line of /proc/stat
+func BootTime() (time.Time, error) {
+	values, err := readKeyValues("/proc/stat", " ")
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(values["btime"], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// StartTime returns when the process was started
+func (s Stat) StartTime() (time.Time, error) {
+	boot, err := BootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return boot.Add(time.Duration(s.StartTicks) * time.Second / ClockTicks), nil
+}
+
+// readKeyValues reads "key<separator>value" lines
+func readKeyValues(path string, separator string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), separator)
+		if found {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,157 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// capabilityNames are the capabilities of capabilities(7) by number
+var capabilityNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner",
+	"cap_fsetid", "cap_kill", "cap_setgid", "cap_setuid",
+	"cap_setpcap", "cap_linux_immutable", "cap_net_bind_service", "cap_net_broadcast",
+	"cap_net_admin", "cap_net_raw", "cap_ipc_lock", "cap_ipc_owner",
+	"cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice",
+	"cap_sys_resource", "cap_sys_time", "cap_sys_tty_config", "cap_mknod",
+	"cap_lease", "cap_audit_write", "cap_audit_control", "cap_setfcap",
+	"cap_mac_override", "cap_mac_admin", "cap_syslog", "cap_wake_alarm",
+	"cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// prctl options and capget/capset version of linux/prctl.h and
+// linux/capability.h that the syscall package lacks
+const (
+	prCapAmbient       = 47
+	prCapAmbientRaise  = 2
+	prSetNoNewPrivs    = 38
+	capabilityVersion3 = 0x20080522
+)
+
+// ParseCapability accepts names like "CAP_NET_BIND_SERVICE" or
+// "net_bind_service"
+func ParseCapability(name string) (uintptr, error) {
+	lower := strings.ToLower(name)
+	if !strings.HasPrefix(lower, "cap_") {
+		lower = "cap_" + lower
+	}
+	for number, candidate := range capabilityNames {
+		if candidate == lower {
+			return uintptr(number), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown capability: %s", name)
+}
+
+// LastCapability returns the highest capability the kernel knows
+func LastCapability() uintptr {
+	content, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err == nil {
+		if last, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+			return uintptr(last)
+		}
+	}
+	return uintptr(len(capabilityNames) - 1)
+}
+
+// CapabilityNames lists the capabilities of a mask from /proc/<pid>/status,
+// "all" when it has every capability of the kernel
+func CapabilityNames(mask uint64) string {
+	last := LastCapability()
+	if mask == 0 {
+		return "none"
+	}
+	if mask == 1<<(last+1)-1 {
+		return "all"
+	}
+	names := []string{}
+	for number := uintptr(0); number <= last; number++ {
+		if mask&(1<<number) == 0 {
+			continue
+		}
+		if int(number) < len(capabilityNames) {
+			names = append(names, capabilityNames[number])
+		} else {
+			names = append(names, fmt.Sprintf("cap_%d", number))
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// DropBounding removes capabilities from the bounding set, no process
+// executed afterwards can gain them
+func DropBounding(capabilities []uintptr) error {
+	for _, capability := range capabilities {
+		if err := prctl(syscall.PR_CAPBSET_DROP, capability, 0); err != nil {
+			return fmt.Errorf("failed to drop %s from the bounding set: %v", capabilityName(capability), err)
+		}
+	}
+	return nil
+}
+
+// KeepCapabilities keeps the permitted capabilities when the user changes
+// from root, RaiseAmbient needs them afterwards
+func KeepCapabilities() error {
+	if err := prctl(syscall.PR_SET_KEEPCAPS, 1, 0); err != nil {
+		return fmt.Errorf("failed to keep capabilities: %v", err)
+	}
+	return nil
+}
+
+// RaiseAmbient makes the capabilities effective, inheritable and ambient, so
+// they survive the exec of a binary without file capabilities
+func RaiseAmbient(capabilities []uintptr) error {
+	header := struct {
+		version uint32
+		pid     int32
+	}{version: capabilityVersion3}
+	var data [2]struct {
+		effective   uint32
+		permitted   uint32
+		inheritable uint32
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("failed to read capabilities: %v", errno)
+	}
+	for _, capability := range capabilities {
+		data[capability/32].effective |= 1 << (capability % 32)
+		data[capability/32].inheritable |= 1 << (capability % 32)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("failed to set capabilities: %v", errno)
+	}
+	for _, capability := range capabilities {
+		if err := prctl(prCapAmbient, prCapAmbientRaise, capability); err != nil {
+			return fmt.Errorf("failed to raise ambient %s: %v", capabilityName(capability), err)
+		}
+	}
+	return nil
+}
+
+// NoNewPrivileges stops setuid binaries and file capabilities from granting
+// privileges to the process and its children
+func NoNewPrivileges() error {
+	if err := prctl(prSetNoNewPrivs, 1, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %v", err)
+	}
+	return nil
+}
+
+func capabilityName(capability uintptr) string {
+	if int(capability) < len(capabilityNames) {
+		return capabilityNames[capability]
+	}
+	return fmt.Sprintf("cap_%d", capability)
+}
+
+func prctl(option uintptr, first uintptr, second uintptr) error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, option, first, second, 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
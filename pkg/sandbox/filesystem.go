@@ -0,0 +1,433 @@
+package sandbox
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"ops-ctrl/pkg/securedir"
+)
+
+// InaccessibleDir holds the empty file and directory that are mounted over
+// inaccessible paths. Only the daemon's user may write to it.
+const InaccessibleDir = securedir.RuntimeDir + "/inaccessible"
+
+// protectSystemPaths are the paths made read-only by the protect_system presets
+var protectSystemPaths = map[string][]string{
+	"no":     nil,
+	"yes":    {"/usr", "/boot", "/efi"},
+	"full":   {"/usr", "/boot", "/efi", "/etc"},
+	"strict": {"/"},
+}
+
+// oPath is O_PATH, which the syscall package lacks. It's the same on the
+// architectures Go supports.
+const oPath = 0x200000
+
+// apiPaths stay writable with protect_system = "strict"
+var apiPaths = []string{"/dev", "/proc", "/sys"}
+
+// Bind mounts Source at Target
+type Bind struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+// Filesystem is the view of the filesystem of a service, set up in its mount
+// namespace. Paths other than bind sources are inside Root.
+type Filesystem struct {
+	Root          string   `json:"root,omitempty"`           // Directory the service is chrooted to
+	ProtectSystem string   `json:"protect_system,omitempty"` // no, yes, full or strict
+	ReadOnly      []string `json:"read_only,omitempty"`
+	Inaccessible  []string `json:"inaccessible,omitempty"`
+	Binds         []Bind   `json:"binds,omitempty"`
+	PrivateTmp    bool     `json:"private_tmp,omitempty"` // Empty /tmp and /var/tmp
+	Keep          []string `json:"keep,omitempty"`        // Host directories that stay visible, like the notify socket directory
+}
+
+// ParseBind parses "source[:target][:ro|rw]", the target defaults to the
+// source
+func ParseBind(value string) (Bind, error) {
+	fields := strings.Split(value, ":")
+	readOnly := false
+	if last := fields[len(fields)-1]; len(fields) > 1 && (last == "ro" || last == "rw") {
+		readOnly = last == "ro"
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) > 2 || fields[0] == "" {
+		return Bind{}, fmt.Errorf("%q is not \"source[:target][:ro|rw]\"", value)
+	}
+	bind := Bind{Source: fields[0], Target: fields[0], ReadOnly: readOnly}
+	if len(fields) == 2 {
+		bind.Target = fields[1]
+	}
+	return bind, nil
+}
+
+// Validate checks the paths before anything is started
+func (f Filesystem) Validate() error {
+	if _, exists := protectSystemPaths[f.ProtectSystem]; !exists && f.ProtectSystem != "" {
+		return fmt.Errorf("invalid protect_system: %s, use no, yes, full or strict", f.ProtectSystem)
+	}
+	if f.Root != "" {
+		if !filepath.IsAbs(f.Root) {
+			return fmt.Errorf("root_directory must be an absolute path: %s", f.Root)
+		}
+		info, err := os.Stat(f.Root)
+		if err != nil {
+			return fmt.Errorf("root_directory %s: %v", f.Root, unwrapPathError(err))
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("root_directory %s is not a directory", f.Root)
+		}
+	}
+	for _, path := range f.ReadOnly {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("read_only_paths must be absolute: %s", path)
+		}
+	}
+	for _, path := range f.Inaccessible {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("inaccessible_paths must be absolute: %s", path)
+		}
+	}
+	for _, bind := range f.Binds {
+		if !filepath.IsAbs(bind.Source) || !filepath.IsAbs(bind.Target) {
+			return fmt.Errorf("bind_paths must be absolute: %s:%s", bind.Source, bind.Target)
+		}
+		if _, err := os.Stat(bind.Source); err != nil {
+			return fmt.Errorf("bind_paths source %s: %v", bind.Source, unwrapPathError(err))
+		}
+	}
+	return nil
+}
+
+// Apply sets up the filesystem in the current mount namespace, its mounts
+// must already be private. The caller changes the root afterwards.
+func (f Filesystem) Apply() error {
+	// Sources are opened first, the mounts below may hide them
+	sources := make([]*os.File, len(f.Binds))
+	for i, bind := range f.Binds {
+		source, err := openPath(bind.Source)
+		if err != nil {
+			return fmt.Errorf("bind_paths source %s: %v", bind.Source, err)
+		}
+		defer source.Close()
+		sources[i] = source
+	}
+	kept := []*os.File{}
+	for _, path := range f.Keep {
+		if source, err := openPath(path); err == nil {
+			defer source.Close()
+			kept = append(kept, source)
+		}
+	}
+	var emptyDir, emptyFile *os.File
+	if len(f.Inaccessible) > 0 {
+		var err error
+		if emptyDir, emptyFile, err = inaccessibleNodes(); err != nil {
+			return err
+		}
+		defer emptyDir.Close()
+		defer emptyFile.Close()
+	}
+
+	// Mount points are created while the paths are still writable, binds
+	// below a private /tmp are created once it is mounted
+	for i, bind := range f.Binds {
+		if err := createMountPoint(sources[i], f.inside(bind.Target)); err != nil {
+			return fmt.Errorf("bind_paths %s:%s: %v", bind.Source, bind.Target, err)
+		}
+	}
+	if f.Root != "" {
+		for i, source := range kept {
+			if err := createMountPoint(source, f.inside(f.Keep[i])); err != nil {
+				return fmt.Errorf("failed to keep %s: %v", f.Keep[i], err)
+			}
+		}
+	}
+
+	for _, path := range protectSystemPaths[f.ProtectSystem] {
+		if _, err := os.Stat(f.inside(path)); err != nil {
+			// Presets name paths that not every system has
+			continue
+		}
+		if err := makeReadOnly(f.inside(path)); err != nil {
+			return fmt.Errorf("protect_system %s: %v", f.ProtectSystem, err)
+		}
+	}
+	for _, path := range f.ReadOnly {
+		if err := makeReadOnly(f.inside(path)); err != nil {
+			return fmt.Errorf("read_only_paths %s: %v", path, err)
+		}
+	}
+
+	if f.PrivateTmp {
+		for _, path := range []string{"/tmp", "/var/tmp"} {
+			if _, err := os.Stat(f.inside(path)); err != nil {
+				continue
+			}
+			if err := syscall.Mount("tmpfs", f.inside(path), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+				return fmt.Errorf("private_tmp %s: %v", path, err)
+			}
+		}
+	}
+
+	for i, bind := range f.Binds {
+		target := f.inside(bind.Target)
+		if err := bindMount(sources[i], target, bind.ReadOnly); err != nil {
+			return fmt.Errorf("bind_paths %s:%s: %v", bind.Source, bind.Target, err)
+		}
+	}
+	if f.PrivateTmp || f.Root != "" {
+		for i, source := range kept {
+			if err := bindMount(source, f.inside(f.Keep[i]), false); err != nil {
+				return fmt.Errorf("failed to keep %s: %v", f.Keep[i], err)
+			}
+		}
+	}
+
+	for _, path := range f.Inaccessible {
+		target := f.inside(path)
+		info, err := os.Stat(target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("inaccessible_paths %s: %v", path, unwrapPathError(err))
+		}
+		node := emptyFile
+		if info.IsDir() {
+			node = emptyDir
+		}
+		if err := bindMount(node, target, true); err != nil {
+			return fmt.Errorf("inaccessible_paths %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// inside returns where path is found before the root is changed
+func (f Filesystem) inside(path string) string {
+	if f.Root == "" {
+		return path
+	}
+	return filepath.Join(f.Root, path)
+}
+
+// Chroot changes the root directory and then the working directory
+func Chroot(root string, workingDir string) error {
+	if err := syscall.Chroot(root); err != nil {
+		return fmt.Errorf("failed to change root to %s: %v", root, err)
+	}
+	if err := os.Chdir(workingDir); err != nil {
+		return fmt.Errorf("working directory %s: %v", workingDir, unwrapPathError(err))
+	}
+	return nil
+}
+
+// openPath opens a path to refer to it through /proc/self/fd
+func openPath(path string) (*os.File, error) {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// inaccessibleNodes opens the empty directory and file of InaccessibleDir,
+// creating them if necessary. Nodes that are not empty, have a mode other
+// than 0 or belong to another user are refused.
+func inaccessibleNodes() (dir *os.File, file *os.File, err error) {
+	dirPath := filepath.Join(InaccessibleDir, "dir")
+	filePath := filepath.Join(InaccessibleDir, "file")
+	if err := securedir.Create(securedir.RuntimeDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("invalid runtime directory: %v", err)
+	}
+	if err := securedir.Create(InaccessibleDir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("invalid inaccessible directory: %v", err)
+	}
+	if err := os.Mkdir(dirPath, 0); err != nil && !os.IsExist(err) {
+		return nil, nil, fmt.Errorf("failed to create %s: %v", dirPath, err)
+	}
+	fd, err := syscall.Open(filePath, syscall.O_CREAT|syscall.O_EXCL|syscall.O_WRONLY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err == nil {
+		syscall.Close(fd)
+	} else if err != syscall.EEXIST {
+		return nil, nil, fmt.Errorf("failed to create %s: %v", filePath, err)
+	}
+
+	if dir, err = openNode(dirPath, syscall.S_IFDIR); err != nil {
+		return nil, nil, err
+	}
+	if file, err = openNode(filePath, syscall.S_IFREG); err != nil {
+		dir.Close()
+		return nil, nil, err
+	}
+	return dir, file, nil
+}
+
+// openNode opens an inaccessible node without following a symbolic link
+// and checks that it is an empty node of kind with mode 0 of the daemon's
+// user
+func openNode(path string, kind uint32) (*os.File, error) {
+	fd, err := syscall.Open(path, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	empty := kind == syscall.S_IFDIR || stat.Size == 0
+	if stat.Mode&syscall.S_IFMT != kind || stat.Mode&07777 != 0 || int(stat.Uid) != os.Geteuid() || !empty {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("%s is not an empty node with mode 0 of UID %d, remove it", path, os.Geteuid())
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// bindMount mounts the file or directory of source at target, which is
+// created like source if missing
+func bindMount(source *os.File, target string, readOnly bool) error {
+	if err := createMountPoint(source, target); err != nil {
+		return err
+	}
+	if err := syscall.Mount(fmt.Sprintf("/proc/self/fd/%d", source.Fd()), target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind mount: %v", err)
+	}
+	// A bind mount copies the flags of the source mount, which may have been
+	// made read-only already
+	return remount(target, readOnly)
+}
+
+// createMountPoint creates target like source, a directory or an empty
+// file, unless it exists
+func createMountPoint(source *os.File, target string) error {
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		return nil
+	}
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+		err = os.WriteFile(target, nil, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create mount point: %v", unwrapPathError(err))
+	}
+	return nil
+}
+
+// makeReadOnly bind mounts path onto itself and remounts it and every mount
+// below read-only. Below / the API filesystems stay writable.
+func makeReadOnly(path string) error {
+	// The root is a mount point, one stacked on it wouldn't be seen
+	if path != "/" {
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to bind mount: %v", err)
+		}
+	}
+	mountPoints, err := mountPointsBelow(path)
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range mountPoints {
+		if path == "/" && below(mountPoint, apiPaths) {
+			continue
+		}
+		if err := remount(mountPoint, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remount makes a mount read-only or writable, it keeps the flags a bind
+// remount in a user namespace may not clear
+func remount(path string, readOnly bool) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return fmt.Errorf("failed to remount: %v", err)
+	}
+	flags := uintptr(stat.Flags)&(syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC|syscall.MS_NOATIME|syscall.MS_NODIRATIME|syscall.MS_RELATIME) |
+		syscall.MS_BIND | syscall.MS_REMOUNT
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	if err := syscall.Mount("", path, "", flags, ""); err != nil {
+		if readOnly {
+			return fmt.Errorf("failed to remount read-only: %v", err)
+		}
+		return fmt.Errorf("failed to remount writable: %v", err)
+	}
+	return nil
+}
+
+// mountPointsBelow lists the mount points at or below path from
+// /proc/self/mountinfo
+func mountPointsBelow(path string) ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mountPoints := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := unescapeMountPoint(fields[4])
+		if below(mountPoint, []string{path}) {
+			mountPoints = append(mountPoints, mountPoint)
+		}
+	}
+	return mountPoints, scanner.Err()
+}
+
+// below reports if path is one of parents or inside one of them
+func below(path string, parents []string) bool {
+	for _, parent := range parents {
+		if path == parent || parent == "/" || strings.HasPrefix(path, parent+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeMountPoint decodes the octal escapes of spaces, tabs, newlines and
+// backslashes in mountinfo
+func unescapeMountPoint(field string) string {
+	var result strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if value, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				result.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		result.WriteByte(field[i])
+	}
+	return result.String()
+}
+
+// unwrapPathError drops the path from errors that already mention it
+func unwrapPathError(err error) error {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
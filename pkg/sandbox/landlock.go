@@ -0,0 +1,174 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock modes for kernels that lack Landlock or some of its access rights
+const (
+	LandlockBestEffort = "best-effort" // Restrict as far as the kernel supports
+	LandlockRequired   = "required"    // Fail the start instead
+)
+
+// System calls and values of linux/landlock.h that the syscall package lacks.
+// The numbers are the same on every architecture.
+const (
+	sysLandlockCreateRuleset   = 444
+	sysLandlockAddRule         = 445
+	sysLandlockRestrictSelf    = 446
+	landlockCreateRulesetVer   = 1 << 0
+	landlockRulePathBeneath    = 1
+	landlockAccessExecute      = 1 << 0
+	landlockAccessWriteFile    = 1 << 1
+	landlockAccessReadFile     = 1 << 2
+	landlockAccessReadDir      = 1 << 3
+	landlockAccessRemoveDir    = 1 << 4
+	landlockAccessRemoveFile   = 1 << 5
+	landlockAccessMakeChar     = 1 << 6
+	landlockAccessMakeDir      = 1 << 7
+	landlockAccessMakeReg      = 1 << 8
+	landlockAccessMakeSock     = 1 << 9
+	landlockAccessMakeFifo     = 1 << 10
+	landlockAccessMakeBlock    = 1 << 11
+	landlockAccessMakeSym      = 1 << 12
+	landlockAccessRefer        = 1 << 13 // ABI 2
+	landlockAccessTruncate     = 1 << 14 // ABI 3
+	landlockAccessIoctlDev     = 1 << 15 // ABI 5
+	landlockAccessFileRights   = landlockAccessExecute | landlockAccessWriteFile | landlockAccessReadFile | landlockAccessTruncate | landlockAccessIoctlDev
+	landlockAccessReadRights   = landlockAccessReadFile | landlockAccessReadDir
+	landlockAccessExecuteRight = landlockAccessReadRights | landlockAccessExecute
+	landlockAccessWriteRights  = landlockAccessReadRights | landlockAccessWriteFile | landlockAccessRemoveDir |
+		landlockAccessRemoveFile | landlockAccessMakeChar | landlockAccessMakeDir | landlockAccessMakeReg |
+		landlockAccessMakeSock | landlockAccessMakeFifo | landlockAccessMakeBlock | landlockAccessMakeSym |
+		landlockAccessRefer | landlockAccessTruncate | landlockAccessIoctlDev
+)
+
+// Landlock restricts filesystem access of the process and everything it
+// executes to the paths below the listed ones
+type Landlock struct {
+	Read    []string `json:"read,omitempty"`    // Files and directories that can be read
+	Write   []string `json:"write,omitempty"`   // Read, written, created and removed
+	Execute []string `json:"execute,omitempty"` // Read and executed
+	Mode    string   `json:"mode,omitempty"`    // best-effort or required, best-effort by default
+}
+
+// LandlockABI returns the Landlock ABI version of the kernel, 0 when Landlock
+// is not supported or disabled
+func LandlockABI() int {
+	version, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVer)
+	if errno != 0 {
+		return 0
+	}
+	return int(version)
+}
+
+// Validate checks the mode and that the paths are absolute
+func (l Landlock) Validate() error {
+	if l.Mode != "" && l.Mode != LandlockBestEffort && l.Mode != LandlockRequired {
+		return fmt.Errorf("invalid landlock_mode: %s, use best-effort or required", l.Mode)
+	}
+	for _, paths := range [][]string{l.Read, l.Write, l.Execute} {
+		for _, path := range paths {
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("landlock path must be absolute: %s", path)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply restricts the calling thread, which must be locked and execute the
+// binary. In best-effort mode access rights the kernel lacks and missing
+// paths are skipped, without Landlock nothing is restricted.
+func (l Landlock) Apply() error {
+	required := l.Mode == LandlockRequired
+	abi := LandlockABI()
+	if abi == 0 {
+		if required {
+			return fmt.Errorf("landlock is required but not supported by the kernel")
+		}
+		return nil
+	}
+
+	handled := uint64(landlockAccessWriteRights | landlockAccessExecute)
+	if abi < 2 {
+		handled &^= landlockAccessRefer
+	}
+	if abi < 3 {
+		handled &^= landlockAccessTruncate
+	}
+	if abi < 5 {
+		handled &^= landlockAccessIoctlDev
+	}
+	attr := struct{ handledAccessFS uint64 }{handled}
+	ruleset, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %v", errno)
+	}
+	defer syscall.Close(int(ruleset))
+
+	rules := []struct {
+		paths  []string
+		access uint64
+	}{
+		{l.Read, landlockAccessReadRights},
+		{l.Write, landlockAccessWriteRights},
+		{l.Execute, landlockAccessExecuteRight},
+	}
+	for _, rule := range rules {
+		for _, path := range rule.paths {
+			err := addLandlockRule(int(ruleset), path, rule.access&handled)
+			if errors.Is(err, os.ErrNotExist) && !required {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, _, errno = syscall.Syscall(sysLandlockRestrictSelf, ruleset, 0, 0)
+	if errno == syscall.EPERM {
+		// Without CAP_SYS_ADMIN the process must not gain privileges
+		if err := NoNewPrivileges(); err != nil {
+			return err
+		}
+		_, _, errno = syscall.Syscall(sysLandlockRestrictSelf, ruleset, 0, 0)
+	}
+	if errno != 0 {
+		return fmt.Errorf("failed to apply landlock ruleset: %v", errno)
+	}
+	return nil
+}
+
+// addLandlockRule allows access below path, only the file rights for a path
+// that is no directory
+func addLandlockRule(ruleset int, path string, access uint64) error {
+	file, err := openPath(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(file.Fd()), &stat); err != nil {
+		return fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= landlockAccessFileRights
+	}
+
+	// struct landlock_path_beneath_attr is packed, the kernel reads 12 bytes
+	attr := struct {
+		allowedAccess uint64
+		parentFD      int32
+	}{access, int32(file.Fd())}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to add landlock rule for %s: %v", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package sandbox
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// PrivateMounts stops mounts of the new mount namespace from propagating to
+// the host, a shared root would otherwise pass them on
+func PrivateMounts() error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %v", err)
+	}
+	return nil
+}
+
+// MountProc mounts a proc filesystem at target that shows the processes of
+// the current PID namespace instead of the host
+func MountProc(target string) error {
+	if err := syscall.Mount("proc", target, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount %s: %v", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package sandbox
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// ifreq is struct ifreq of netdevice(7) with the flags member of the union
+type ifreq struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+// Loopback brings up the loopback interface of the network namespace, a new
+// network namespace starts with lo down
+func Loopback() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to bring up lo: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	var request ifreq
+	copy(request.name[:], "lo")
+	if err := ioctl(fd, syscall.SIOCGIFFLAGS, &request); err != nil {
+		return fmt.Errorf("failed to bring up lo: %v", err)
+	}
+	request.flags |= syscall.IFF_UP | syscall.IFF_RUNNING
+	if err := ioctl(fd, syscall.SIOCSIFFLAGS, &request); err != nil {
+		return fmt.Errorf("failed to bring up lo: %v", err)
+	}
+	return nil
+}
+
+func ioctl(fd int, request uintptr, argument *ifreq) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(argument)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// cpuPolicies are the scheduling policies of sched(7) by name
+var cpuPolicies = map[string]int{
+	"other": 0,
+	"fifo":  1,
+	"rr":    2,
+	"batch": 3,
+	"idle":  5,
+}
+
+// ioClasses are the I/O scheduling classes of ioprio_set(2) by name, "none"
+// follows the CPU nice value
+var ioClasses = map[string]int{
+	"none":        0,
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// Values of linux/ioprio.h and the size of the CPU set of sched_setaffinity
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioDataMask   = 1<<ioprioClassShift - 1
+	maxCPUs          = 1024
+)
+
+// Scheduling holds the CPU, I/O and memory pressure settings of a process,
+// nil pointers and empty values leave the inherited ones
+type Scheduling struct {
+	Nice           *int   `json:"nice,omitempty"`
+	CPUAffinity    []int  `json:"cpu_affinity,omitempty"`
+	CPUPolicy      string `json:"cpu_policy,omitempty"`
+	CPUPriority    int    `json:"cpu_priority,omitempty"` // 1-99 for fifo and rr
+	IOClass        string `json:"io_class,omitempty"`
+	IOPriority     int    `json:"io_priority,omitempty"` // 0 (highest) to 7 for realtime and best-effort
+	OOMScoreAdjust *int   `json:"oom_score_adjust,omitempty"`
+	Umask          *int   `json:"umask,omitempty"`
+}
+
+// Validate checks the ranges of the values
+func (s Scheduling) Validate() error {
+	if s.Nice != nil && (*s.Nice < -20 || *s.Nice > 19) {
+		return fmt.Errorf("nice must be between -20 and 19: %d", *s.Nice)
+	}
+	for _, cpu := range s.CPUAffinity {
+		if cpu < 0 || cpu >= maxCPUs {
+			return fmt.Errorf("cpu_affinity must list CPUs between 0 and %d: %d", maxCPUs-1, cpu)
+		}
+	}
+	if s.CPUPolicy != "" {
+		if _, exists := cpuPolicies[s.CPUPolicy]; !exists {
+			return fmt.Errorf("invalid cpu_scheduling_policy: %s, use other, batch, idle, fifo or rr", s.CPUPolicy)
+		}
+	}
+	realtime := s.CPUPolicy == "fifo" || s.CPUPolicy == "rr"
+	if realtime && (s.CPUPriority < 1 || s.CPUPriority > 99) {
+		return fmt.Errorf("cpu_scheduling_priority must be between 1 and 99 for %s: %d", s.CPUPolicy, s.CPUPriority)
+	}
+	if !realtime && s.CPUPriority != 0 {
+		return fmt.Errorf("cpu_scheduling_priority requires the fifo or rr cpu_scheduling_policy")
+	}
+	if s.IOClass != "" {
+		if _, exists := ioClasses[s.IOClass]; !exists {
+			return fmt.Errorf("invalid io_scheduling_class: %s, use realtime, best-effort, idle or none", s.IOClass)
+		}
+	}
+	if s.IOPriority < 0 || s.IOPriority > 7 {
+		return fmt.Errorf("io_scheduling_priority must be between 0 and 7: %d", s.IOPriority)
+	}
+	if s.OOMScoreAdjust != nil && (*s.OOMScoreAdjust < -1000 || *s.OOMScoreAdjust > 1000) {
+		return fmt.Errorf("oom_score_adjust must be between -1000 and 1000: %d", *s.OOMScoreAdjust)
+	}
+	if s.Umask != nil && (*s.Umask < 0 || *s.Umask > 0777) {
+		return fmt.Errorf("umask must be between 0000 and 0777: %04o", *s.Umask)
+	}
+	return nil
+}
+
+// Apply sets the values for the calling thread, which must be locked and
+// execute the binary. Raising priorities needs privileges, it comes before
+// the user changes.
+func (s Scheduling) Apply() error {
+	if s.Nice != nil {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *s.Nice); err != nil {
+			return fmt.Errorf("failed to set nice %d: %v", *s.Nice, err)
+		}
+	}
+	if len(s.CPUAffinity) > 0 {
+		var set [maxCPUs / 64]uint64
+		for _, cpu := range s.CPUAffinity {
+			set[cpu/64] |= 1 << (cpu % 64)
+		}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(set), uintptr(unsafe.Pointer(&set[0]))); errno != 0 {
+			return fmt.Errorf("failed to set cpu_affinity %s: %v", FormatCPUList(s.CPUAffinity), errno)
+		}
+	}
+	if s.CPUPolicy != "" {
+		param := struct{ priority int32 }{int32(s.CPUPriority)}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0, uintptr(cpuPolicies[s.CPUPolicy]), uintptr(unsafe.Pointer(&param))); errno != 0 {
+			return fmt.Errorf("failed to set cpu_scheduling_policy %s: %v", s.CPUPolicy, errno)
+		}
+	}
+	if s.IOClass != "" {
+		value := ioClasses[s.IOClass]<<ioprioClassShift | s.IOPriority
+		if s.IOClass == "idle" || s.IOClass == "none" {
+			value = ioClasses[s.IOClass] << ioprioClassShift
+		}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, uintptr(value)); errno != 0 {
+			return fmt.Errorf("failed to set io_scheduling_class %s: %v", s.IOClass, errno)
+		}
+	}
+	if s.OOMScoreAdjust != nil {
+		if err := os.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(*s.OOMScoreAdjust)), 0); err != nil {
+			return fmt.Errorf("failed to set oom_score_adjust: %v", unwrapPathError(err))
+		}
+	}
+	if s.Umask != nil {
+		syscall.Umask(*s.Umask)
+	}
+	return nil
+}
+
+// ParseCPUList parses CPU lists like "0-3,6" of cpuset(7)
+func ParseCPUList(value string) ([]int, error) {
+	cpus := []int{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list: %s", value)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid CPU list: %s", value)
+			}
+		}
+		// Checked here already, a large range would fill memory
+		if end >= maxCPUs {
+			return nil, fmt.Errorf("invalid CPU list: %s, CPUs go up to %d", value, maxCPUs-1)
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// FormatCPUList joins consecutive CPUs to ranges, the reverse of
+// ParseCPUList
+func FormatCPUList(cpus []int) string {
+	sorted := append([]int{}, cpus...)
+	sort.Ints(sorted)
+	parts := []string{}
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// PolicyName returns the name of a scheduling policy number of
+// /proc/<pid>/stat
+func PolicyName(policy int) string {
+	for name, number := range cpuPolicies {
+		if number == policy {
+			return name
+		}
+	}
+	return strconv.Itoa(policy)
+}
+
+// IOPriority returns the I/O scheduling class and priority of a process
+func IOPriority(pid int) (class string, priority int, err error) {
+	value, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(pid), 0)
+	if errno != 0 {
+		return "", 0, errno
+	}
+	for name, number := range ioClasses {
+		if number == int(value>>ioprioClassShift) {
+			class = name
+		}
+	}
+	return class, int(value & ioprioDataMask), nil
+}
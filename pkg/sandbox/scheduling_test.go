@@ -0,0 +1,80 @@
+package sandbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{value: "0", want: []int{0}},
+		{value: "0-3", want: []int{0, 1, 2, 3}},
+		{value: "0-3,6", want: []int{0, 1, 2, 3, 6}},
+		{value: " 1 , 4-5 ", want: []int{1, 4, 5}},
+		{value: "2-2", want: []int{2}},
+		{value: "1023", want: []int{1023}},
+		{value: "", wantErr: true},
+		{value: "0,", wantErr: true},
+		{value: "a", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "3-1", wantErr: true},
+		{value: "0-", wantErr: true},
+		{value: "0-1-2", wantErr: true},
+		{value: "1024", wantErr: true},
+		{value: "0-2000000000", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseCPUList(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseCPUList(%q) = %v, want an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCPUList(%q) error = %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseCPUList(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	tests := []struct {
+		cpus []int
+		want string
+	}{
+		{cpus: nil, want: ""},
+		{cpus: []int{0}, want: "0"},
+		{cpus: []int{0, 1, 2, 3}, want: "0-3"},
+		{cpus: []int{0, 1, 2, 3, 6}, want: "0-3,6"},
+		{cpus: []int{6, 3, 1, 2, 0}, want: "0-3,6"},
+		{cpus: []int{1, 1, 2}, want: "1-2"},
+		{cpus: []int{1, 3, 5}, want: "1,3,5"},
+	}
+
+	for _, test := range tests {
+		if got := FormatCPUList(test.cpus); got != test.want {
+			t.Errorf("FormatCPUList(%v) = %q, want %q", test.cpus, got, test.want)
+		}
+	}
+}
+
+func TestCPUListRoundTrip(t *testing.T) {
+	for _, value := range []string{"0", "0-3", "0-3,6", "1,3,5-7,1000-1023"} {
+		cpus, err := ParseCPUList(value)
+		if err != nil {
+			t.Fatalf("ParseCPUList(%q) error = %v", value, err)
+		}
+		if got := FormatCPUList(cpus); got != value {
+			t.Errorf("FormatCPUList(ParseCPUList(%q)) = %q", value, got)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Actions for the system calls a filter doesn't allow
+const (
+	ActionKill  = "kill"  // Kill the process
+	ActionErrno = "errno" // Fail the call with EPERM
+	ActionLog   = "log"   // Allow and log the call
+)
+
+// Values of linux/seccomp.h and linux/filter.h that the syscall package
+// lacks
+const (
+	seccompModeFilter     = 2
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+	seccompDataNumber     = 0 // Offset of nr in struct seccomp_data
+	seccompDataArch       = 4 // Offset of arch
+	bpfLoadWord           = syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS
+	bpfJumpEqual          = syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K
+	bpfJumpGreaterOrEqual = syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K
+	bpfReturn             = syscall.BPF_RET | syscall.BPF_K
+	maxFilterInstructions = 4096
+)
+
+// requiredSyscalls are allowed by every allow list. The exec helper installs
+// the filter before it executes the binary, until then the Go runtime may
+// allocate, wake other threads and handle signals on its thread, and a failed
+// exec is reported with write.
+var requiredSyscalls = []string{
+	"execve", "exit", "exit_group", "rt_sigreturn",
+	"futex", "getpid", "gettid", "madvise", "mmap", "mprotect", "munmap", "nanosleep",
+	"rt_sigaction", "rt_sigprocmask", "sched_yield", "sigaltstack", "tgkill", "write",
+}
+
+// SyscallFilter is a seccomp filter built from system call names and groups
+// like "@system-service"
+type SyscallFilter struct {
+	Allow  []string `json:"allow,omitempty"`  // Only these are allowed when set
+	Deny   []string `json:"deny,omitempty"`   // Never allowed
+	Action string   `json:"action,omitempty"` // For the others, kill, errno or log
+}
+
+// Validate checks names, groups and the action
+func (f SyscallFilter) Validate() error {
+	_, err := f.compile()
+	return err
+}
+
+// Install loads the filter for the calling thread, which must be locked and
+// execute the binary. no_new_privs is set if the process lacks CAP_SYS_ADMIN.
+func (f SyscallFilter) Install() error {
+	program, err := f.compile()
+	if err != nil {
+		return err
+	}
+	header := struct {
+		length uint16
+		filter *syscall.SockFilter
+	}{uint16(len(program)), &program[0]}
+
+	err = prctl(syscall.PR_SET_SECCOMP, seccompModeFilter, uintptr(unsafe.Pointer(&header)))
+	if err == syscall.EACCES {
+		if err = NoNewPrivileges(); err == nil {
+			err = prctl(syscall.PR_SET_SECCOMP, seccompModeFilter, uintptr(unsafe.Pointer(&header)))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to install seccomp filter: %v", err)
+	}
+	return nil
+}
+
+// compile builds the BPF program: system calls of other architectures are
+// killed, then every listed number is compared in turn
+func (f SyscallFilter) compile() ([]syscall.SockFilter, error) {
+	if syscallNumbers == nil {
+		return nil, fmt.Errorf("seccomp filters are not supported on %s", runtime.GOARCH)
+	}
+	action, err := filterAction(f.Action)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := resolveSyscalls(f.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid system_call_deny: %v", err)
+	}
+
+	listed := denied
+	matched, unmatched := action, uint32(seccompRetAllow)
+	if len(f.Allow) > 0 {
+		allowed, err := resolveSyscalls(append(f.Allow[:len(f.Allow):len(f.Allow)], requiredSyscalls...))
+		if err != nil {
+			return nil, fmt.Errorf("invalid system_call_allow: %v", err)
+		}
+		for number := range denied {
+			delete(allowed, number)
+		}
+		listed = allowed
+		matched, unmatched = seccompRetAllow, action
+	}
+
+	numbers := []uint32{}
+	for number := range listed {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	program := []syscall.SockFilter{
+		{Code: bpfLoadWord, K: seccompDataArch},
+		{Code: bpfJumpEqual, Jt: 1, K: auditArch},
+		{Code: bpfReturn, K: seccompRetKillProcess},
+		{Code: bpfLoadWord, K: seccompDataNumber},
+	}
+	if x32Bit != 0 {
+		// Logging would let x32 calls through, they get around both lists
+		x32Action := action
+		if x32Action == seccompRetLog {
+			x32Action = seccompRetKillProcess
+		}
+		program = append(program,
+			syscall.SockFilter{Code: bpfJumpGreaterOrEqual, Jf: 1, K: x32Bit},
+			syscall.SockFilter{Code: bpfReturn, K: x32Action},
+		)
+	}
+	for _, number := range numbers {
+		program = append(program,
+			syscall.SockFilter{Code: bpfJumpEqual, Jf: 1, K: number},
+			syscall.SockFilter{Code: bpfReturn, K: matched},
+		)
+	}
+	program = append(program, syscall.SockFilter{Code: bpfReturn, K: unmatched})
+
+	if len(program) > maxFilterInstructions {
+		return nil, fmt.Errorf("seccomp filter too long: %d instructions", len(program))
+	}
+	return program, nil
+}
+
+func filterAction(name string) (uint32, error) {
+	switch name {
+	case ActionKill, "":
+		return seccompRetKillProcess, nil
+	case ActionErrno:
+		return seccompRetErrno | uint32(syscall.EPERM), nil
+	case ActionLog:
+		return seccompRetLog, nil
+	}
+	return 0, fmt.Errorf("invalid system_call_action: %s, use kill, errno or log", name)
+}
+
+// resolveSyscalls expands groups to the numbers of their system calls.
+// Listed names must exist on the architecture, group members may not.
+func resolveSyscalls(names []string) (map[uint32]bool, error) {
+	numbers := make(map[uint32]bool)
+	for _, name := range names {
+		if strings.HasPrefix(name, "@") {
+			if err := expandGroup(name, numbers, map[string]bool{}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		number, exists := syscallNumbers[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown system call on %s: %s", runtime.GOARCH, name)
+		}
+		numbers[number] = true
+	}
+	return numbers, nil
+}
+
+func expandGroup(group string, numbers map[uint32]bool, seen map[string]bool) error {
+	members, exists := syscallGroups[group]
+	if !exists {
+		return fmt.Errorf("unknown system call group: %s", group)
+	}
+	if seen[group] {
+		return nil
+	}
+	seen[group] = true
+	for _, member := range members {
+		if strings.HasPrefix(member, "@") {
+			if err := expandGroup(member, numbers, seen); err != nil {
+				return err
+			}
+		} else if number, exists := syscallNumbers[member]; exists {
+			numbers[number] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package sandbox
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// requireSyscalls skips tests that need the system call numbers of the
+// architecture
+func requireSyscalls(t *testing.T) {
+	t.Helper()
+	if syscallNumbers == nil {
+		t.Skip("no system call numbers for this architecture")
+	}
+}
+
+// run executes program for a system call like the kernel does and returns
+// the action
+func run(t *testing.T, program []syscall.SockFilter, arch uint32, number uint32) uint32 {
+	t.Helper()
+	var accumulator uint32
+	for pc := 0; pc < len(program); pc++ {
+		instruction := program[pc]
+		switch instruction.Code {
+		case bpfLoadWord:
+			switch instruction.K {
+			case seccompDataArch:
+				accumulator = arch
+			case seccompDataNumber:
+				accumulator = number
+			default:
+				t.Fatalf("load of unknown offset %d", instruction.K)
+			}
+		case bpfJumpEqual:
+			if accumulator == instruction.K {
+				pc += int(instruction.Jt)
+			} else {
+				pc += int(instruction.Jf)
+			}
+		case bpfJumpGreaterOrEqual:
+			if accumulator >= instruction.K {
+				pc += int(instruction.Jt)
+			} else {
+				pc += int(instruction.Jf)
+			}
+		case bpfReturn:
+			return instruction.K
+		default:
+			t.Fatalf("unknown instruction %#x", instruction.Code)
+		}
+	}
+	t.Fatal("program ended without returning")
+	return 0
+}
+
+func TestSyscallGroups(t *testing.T) {
+	requireSyscalls(t)
+	for group, members := range syscallGroups {
+		for _, member := range members {
+			if strings.HasPrefix(member, "@") {
+				if _, exists := syscallGroups[member]; !exists {
+					t.Errorf("%s includes the unknown group %s", group, member)
+				}
+			}
+		}
+		numbers, err := resolveSyscalls([]string{group})
+		if err != nil {
+			t.Errorf("resolveSyscalls(%s) error = %v", group, err)
+		} else if len(numbers) == 0 {
+			t.Errorf("%s has no system calls on this architecture", group)
+		}
+	}
+}
+
+func TestResolveSyscalls(t *testing.T) {
+	requireSyscalls(t)
+	tests := []struct {
+		name     string
+		names    []string
+		includes []string
+		excludes []string
+		wantErr  string
+	}{
+		{
+			name:     "names",
+			names:    []string{"read", "write"},
+			includes: []string{"read", "write"},
+			excludes: []string{"close"},
+		},
+		{
+			name:     "group",
+			names:    []string{"@basic-io"},
+			includes: []string{"read", "write", "close", "lseek"},
+			excludes: []string{"mount"},
+		},
+		{
+			name:     "nested groups",
+			names:    []string{"@system-service"},
+			includes: []string{"read", "chown", "fchownat", "umask"},
+			excludes: []string{"mount", "reboot", "init_module"},
+		},
+		{
+			name:     "group and name",
+			names:    []string{"@mount", "reboot"},
+			includes: []string{"mount", "umount2", "reboot"},
+			excludes: []string{"read"},
+		},
+		{
+			name:    "unknown system call",
+			names:   []string{"read", "no_such_call"},
+			wantErr: "unknown system call",
+		},
+		{
+			name:    "unknown group",
+			names:   []string{"@no-such-group"},
+			wantErr: "unknown system call group: @no-such-group",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			numbers, err := resolveSyscalls(test.names)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("resolveSyscalls() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveSyscalls() error = %v", err)
+			}
+			for _, name := range test.includes {
+				if !numbers[syscallNumbers[name]] {
+					t.Errorf("resolveSyscalls() lacks %s", name)
+				}
+			}
+			for _, name := range test.excludes {
+				if numbers[syscallNumbers[name]] {
+					t.Errorf("resolveSyscalls() includes %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestSyscallFilterCompile(t *testing.T) {
+	requireSyscalls(t)
+	errno := uint32(seccompRetErrno | uint32(syscall.EPERM))
+	tests := []struct {
+		name    string
+		filter  SyscallFilter
+		want    map[string]uint32 // Action by system call
+		wantErr string
+	}{
+		{
+			name:   "deny list kills by default",
+			filter: SyscallFilter{Deny: []string{"@mount"}},
+			want:   map[string]uint32{"mount": seccompRetKillProcess, "umount2": seccompRetKillProcess, "read": seccompRetAllow},
+		},
+		{
+			name:   "deny list with errno",
+			filter: SyscallFilter{Deny: []string{"reboot"}, Action: ActionErrno},
+			want:   map[string]uint32{"reboot": errno, "mount": seccompRetAllow},
+		},
+		{
+			name:   "allow list",
+			filter: SyscallFilter{Allow: []string{"@basic-io"}, Action: ActionLog},
+			want:   map[string]uint32{"read": seccompRetAllow, "mount": seccompRetLog, "execve": seccompRetAllow, "exit_group": seccompRetAllow},
+		},
+		{
+			name:   "deny wins over allow",
+			filter: SyscallFilter{Allow: []string{"@system-service"}, Deny: []string{"chown", "fchownat"}, Action: ActionErrno},
+			want:   map[string]uint32{"read": seccompRetAllow, "chown": errno, "fchownat": errno, "mount": errno},
+		},
+		{
+			name:   "required calls can be denied",
+			filter: SyscallFilter{Allow: []string{"read"}, Deny: []string{"execve"}},
+			want:   map[string]uint32{"read": seccompRetAllow, "execve": seccompRetKillProcess, "exit": seccompRetAllow},
+		},
+		{
+			name:    "invalid action",
+			filter:  SyscallFilter{Deny: []string{"reboot"}, Action: "ignore"},
+			wantErr: "invalid system_call_action",
+		},
+		{
+			name:    "invalid allow list",
+			filter:  SyscallFilter{Allow: []string{"no_such_call"}},
+			wantErr: "invalid system_call_allow",
+		},
+		{
+			name:    "invalid deny list",
+			filter:  SyscallFilter{Deny: []string{"@no-such-group"}},
+			wantErr: "invalid system_call_deny",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program, err := test.filter.compile()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("compile() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compile() error = %v", err)
+			}
+			for name, want := range test.want {
+				number, exists := syscallNumbers[name]
+				if !exists {
+					t.Fatalf("unknown system call %s", name)
+				}
+				if got := run(t, program, auditArch, number); got != want {
+					t.Errorf("%s returns %#x, want %#x", name, got, want)
+				}
+			}
+			if got := run(t, program, auditArch+1, syscallNumbers["read"]); got != seccompRetKillProcess {
+				t.Errorf("other architecture returns %#x, want %#x", got, uint32(seccompRetKillProcess))
+			}
+			if x32Bit != 0 {
+				if got := run(t, program, auditArch, x32Bit|syscallNumbers["read"]); got == seccompRetAllow || got == seccompRetLog {
+					t.Errorf("x32 system call returns %#x, want it killed or failed", got)
+				}
+			}
+		})
+	}
+}
+
+func TestSyscallFilterDoesNotChangeAllow(t *testing.T) {
+	requireSyscalls(t)
+	allow := make([]string, 1, 4)
+	allow[0] = "read"
+	filter := SyscallFilter{Allow: allow}
+	if _, err := filter.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if extended := allow[:cap(allow)]; extended[1] != "" {
+		t.Errorf("compile() wrote the required calls into the Allow array: %q", extended)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !amd64 && !arm64
+
+package sandbox
+
+// Seccomp filters need the system call numbers of the architecture, there
+// are none for the other architectures yet
+const (
+	auditArch = 0
+	x32Bit    = 0
+)
+
+var syscallNumbers map[string]uint32
@@ -0,0 +1,86 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl requests of asm-generic/ioctls.h that the syscall package lacks
+const (
+	tiocVHangup = 0x5437
+	tiocNXCL    = 0x540d
+	tcflsh      = 0x540b
+	tcioFlush   = 2
+)
+
+// OpenTerminal opens a terminal device for a service, without making it the
+// controlling terminal of the daemon
+func OpenTerminal(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open terminal: %v", unwrapPathError(err))
+	}
+	var termios syscall.Termios
+	if err := terminalIoctl(file, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a terminal: %v", path, err)
+	}
+	return file, nil
+}
+
+// HangupTerminal hangs up the terminal, processes of the previous session
+// that still have it open lose it
+func HangupTerminal(terminal *os.File) error {
+	if err := terminalIoctl(terminal, tiocVHangup, 0); err != nil {
+		return fmt.Errorf("failed to hang up %s: %v", terminal.Name(), err)
+	}
+	return nil
+}
+
+// ResetTerminal restores sane terminal settings, discards pending input and
+// output and resets the screen, like what a previous session changed
+func ResetTerminal(terminal *os.File) error {
+	// Exclusive mode would keep the next service from opening it
+	terminalIoctl(terminal, tiocNXCL, 0)
+
+	var termios syscall.Termios
+	if err := terminalIoctl(terminal, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))); err != nil {
+		return fmt.Errorf("failed to read settings of %s: %v", terminal.Name(), err)
+	}
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.IUCLC
+	termios.Iflag |= syscall.ICRNL | syscall.IMAXBEL | syscall.IUTF8
+	termios.Oflag |= syscall.ONLCR | syscall.OPOST
+	termios.Cflag |= syscall.CREAD
+	termios.Lflag = syscall.ISIG | syscall.ICANON | syscall.IEXTEN | syscall.ECHO | syscall.ECHOE | syscall.ECHOK |
+		syscall.ECHOCTL | syscall.ECHOKE
+	termios.Cc[syscall.VINTR] = 03  // ^C
+	termios.Cc[syscall.VQUIT] = 034 // ^\
+	termios.Cc[syscall.VERASE] = 0177
+	termios.Cc[syscall.VKILL] = 025  // ^U
+	termios.Cc[syscall.VEOF] = 04    // ^D
+	termios.Cc[syscall.VSTART] = 021 // ^Q
+	termios.Cc[syscall.VSTOP] = 023  // ^S
+	termios.Cc[syscall.VSUSP] = 032  // ^Z
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := terminalIoctl(terminal, syscall.TCSETS, uintptr(unsafe.Pointer(&termios))); err != nil {
+		return fmt.Errorf("failed to reset settings of %s: %v", terminal.Name(), err)
+	}
+	if err := terminalIoctl(terminal, tcflsh, tcioFlush); err != nil {
+		return fmt.Errorf("failed to flush %s: %v", terminal.Name(), err)
+	}
+	// Full reset, scroll region, home and clear the scrollback
+	if _, err := terminal.WriteString("\033c\033[r\033[H\033[3J"); err != nil {
+		return fmt.Errorf("failed to reset %s: %v", terminal.Name(), err)
+	}
+	return nil
+}
+
+func terminalIoctl(file *os.File, request uintptr, argument uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), request, argument); errno != 0 {
+		return errno
+	}
+	return nil
+}
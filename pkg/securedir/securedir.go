@@ -0,0 +1,47 @@
+package securedir
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// RuntimeDir holds the sockets and files of the daemon that don't survive a
+// reboot. It is readable by everyone, so services of other users reach their
+// notification sockets, the directories below it that are private are 0700.
+const RuntimeDir = "/run/ops-ctrl"
+
+// Create creates the directory with its missing parents and makes sure it
+// has mode and isn't controlled by another user. A directory created in
+// advance by someone else is refused rather than used.
+func Create(path string, mode os.FileMode) error {
+	if err := os.MkdirAll(path, mode); err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	if err := Check(path); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to set the mode of %s: %v", path, err)
+	}
+	return nil
+}
+
+// Check returns an error unless path is a file or directory, not a symbolic
+// link, owned by the user of the daemon and not writable by group or others
+func Check(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		return fmt.Errorf("%s is not a regular file or directory", path)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("%s is owned by UID %d instead of %d", path, stat.Uid, os.Geteuid())
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by group or others (mode %04o)", path, info.Mode().Perm())
+	}
+	return nil
+}
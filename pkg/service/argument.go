@@ -10,11 +10,20 @@ const (
 	ProgramArguments Argument = "program_argument" // Arguments for the program binary
 	PID              Argument = "pid"              // PID number for the service
 	WorkingDir       Argument = "working_dir"      // Working directory for the program
+	Type             Argument = "type"             // Service type: simple, exec, forking or notify
+	PIDFile          Argument = "pid_file"         // PID file of a forking service
+	LogPath          Argument = "log_path"         // File the program output is appended to
+	EnvFile          Argument = "env_file"         // dotenv files with environment variables
+	User             Argument = "user"             // User name or UID the program runs as
+	Group            Argument = "group"            // Group name or GID the program runs as
+	Timeout          Argument = "timeout"          // Stop timeout before escalating to SIGKILL
+	Replace          Argument = "replace"          // Stop and replace a service with the same ID, takes no value
+	Descendants      Argument = "descendants"      // Include the processes below the main PID in the usage, takes no value
 )
 
 func (m Argument) IsValid() bool {
 	switch m {
-	case Binary, ID, Alias, Envs, ProgramArguments, PID, WorkingDir:
+	case Binary, ID, Alias, Envs, ProgramArguments, PID, WorkingDir, Type, PIDFile, LogPath, EnvFile, User, Group, Timeout, Replace, Descendants:
 		return true
 	}
 	return false
@@ -22,7 +31,7 @@ func (m Argument) IsValid() bool {
 
 func (m Argument) SupportsArrays() bool {
 	switch m {
-	case Envs, ProgramArguments:
+	case Envs, ProgramArguments, EnvFile:
 		return true
 	}
 	return false
@@ -112,17 +121,78 @@ func CheckArguments(args []string) map[Argument]interface{} {
 	}
 	handleArguments(args, validArgs, workingDirValues, WorkingDir)
 
+	typeValues := map[string]bool{
+		"-t":     true,
+		"--type": true,
+	}
+	handleArguments(args, validArgs, typeValues, Type)
+
+	pidFileValues := map[string]bool{
+		"--pid_file": true,
+		"--pidfile":  true,
+	}
+	handleArguments(args, validArgs, pidFileValues, PIDFile)
+
+	logPathValues := map[string]bool{
+		"-l":         true,
+		"--log_path": true,
+		"--log":      true,
+	}
+	handleArguments(args, validArgs, logPathValues, LogPath)
+
+	envFileValues := map[string]bool{
+		"--env_file": true,
+		"--envfile":  true,
+	}
+	handleArguments(args, validArgs, envFileValues, EnvFile)
+
+	userValues := map[string]bool{
+		"-u":     true,
+		"--user": true,
+	}
+	handleArguments(args, validArgs, userValues, User)
+
+	groupValues := map[string]bool{
+		"-g":      true,
+		"--group": true,
+	}
+	handleArguments(args, validArgs, groupValues, Group)
+
+	timeoutValues := map[string]bool{
+		"-T":        true,
+		"--timeout": true,
+	}
+	handleArguments(args, validArgs, timeoutValues, Timeout)
+
+	replaceValues := map[string]bool{
+		"--replace": true,
+	}
+	handleFlag(args, validArgs, replaceValues, Replace)
+
+	descendantsValues := map[string]bool{
+		"--descendants": true,
+		"--tree":        true,
+	}
+	handleFlag(args, validArgs, descendantsValues, Descendants)
+
 	return validArgs
 }
 
+// checkArgument finds the value of targetType, arguments that support arrays
+// collect the value of every occurrence into a []string
 func checkArgument(args []string, targetValues map[string]bool, targetType Argument) map[Argument]interface{} {
 	validArgs := make(map[Argument]interface{})
-	for i := 0; i < len(args); i++ {
+	values := []string{}
+	for i := 0; i+1 < len(args); i++ {
 		arg := args[i]
 		if targetValues[arg] {
 			validArgs[targetType] = args[i+1]
+			values = append(values, args[i+1])
 		}
 	}
+	if targetType.SupportsArrays() && len(values) > 0 {
+		validArgs[targetType] = values
+	}
 	return validArgs
 }
 
@@ -142,3 +212,13 @@ func handleArguments(args []string, originalArguments map[Argument]interface{},
 	newArguments := checkArgument(args, values, argumentType)
 	addArguments(originalArguments, newArguments)
 }
+
+// handleFlag sets argumentType to true if one of values is present, flags
+// take no value
+func handleFlag(args []string, originalArguments map[Argument]interface{}, values map[string]bool, argumentType Argument) {
+	for _, arg := range args {
+		if values[arg] {
+			originalArguments[argumentType] = true
+		}
+	}
+}
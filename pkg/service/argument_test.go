@@ -0,0 +1,39 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckArgumentsEnvironment(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "single variable",
+			args: []string{"start", "-b", "/bin/true", "-e", "A=1"},
+			want: []string{"A=1"},
+		},
+		{
+			name: "repeated",
+			args: []string{"start", "-e", "A=1", "--env", "B=2"},
+			want: []string{"A=1", "B=2"},
+		},
+		{
+			name: "commas stay in the value",
+			args: []string{"start", "-e", "LIST=a,b", "-e", "URL=http://h/?a=1,b=2", "-e", "PAIR=A=1,B=2"},
+			want: []string{"LIST=a,b", "URL=http://h/?a=1,b=2", "PAIR=A=1,B=2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := CheckArguments(test.args)[Envs]
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("CheckArguments() env = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"ops-ctrl/pkg/procfs"
+	"ops-ctrl/pkg/sandbox"
+)
+
+// boundingDrops returns the capabilities dropped from the bounding set, the
+// option lists the ones kept or, with a "~" prefix, the ones dropped
+func (o Options) boundingDrops() ([]uintptr, error) {
+	if len(o.CapabilityBoundingSet) == 0 {
+		return nil, nil
+	}
+	inverted := strings.HasPrefix(o.CapabilityBoundingSet[0], "~")
+	listed := make(map[uintptr]bool)
+	for _, name := range o.CapabilityBoundingSet {
+		if strings.HasPrefix(name, "~") != inverted {
+			return nil, fmt.Errorf("capability_bounding_set mixes kept and \"~\" dropped capabilities")
+		}
+		capability, err := sandbox.ParseCapability(strings.TrimPrefix(name, "~"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid capability_bounding_set: %v", err)
+		}
+		listed[capability] = true
+	}
+
+	drops := []uintptr{}
+	for capability := uintptr(0); capability <= sandbox.LastCapability(); capability++ {
+		if listed[capability] == inverted {
+			drops = append(drops, capability)
+		}
+	}
+	return drops, nil
+}
+
+func (o Options) ambientCapabilities() ([]uintptr, error) {
+	capabilities := []uintptr{}
+	for _, name := range o.AmbientCapabilities {
+		capability, err := sandbox.ParseCapability(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ambient_capabilities: %v", err)
+		}
+		capabilities = append(capabilities, capability)
+	}
+	return capabilities, nil
+}
+
+func (o Options) validateCapabilities() error {
+	drops, err := o.boundingDrops()
+	if err != nil {
+		return err
+	}
+	ambient, err := o.ambientCapabilities()
+	if err != nil {
+		return err
+	}
+	for i, capability := range ambient {
+		for _, dropped := range drops {
+			if capability == dropped {
+				return fmt.Errorf("ambient capability %s is not in the capability_bounding_set", o.AmbientCapabilities[i])
+			}
+		}
+	}
+	return nil
+}
+
+// Capabilities returns the capability sets of the running main process by
+// name, nil when it doesn't run
+func (s *Service) Capabilities() map[string]string {
+	if s.Process.Status() != "running" {
+		return nil
+	}
+	capabilities, err := procfs.ReadCapabilities(s.GetPID())
+	if err != nil {
+		return nil
+	}
+	return map[string]string{
+		"effective":   sandbox.CapabilityNames(capabilities.Effective),
+		"permitted":   sandbox.CapabilityNames(capabilities.Permitted),
+		"inheritable": sandbox.CapabilityNames(capabilities.Inheritable),
+		"bounding":    sandbox.CapabilityNames(capabilities.Bounding),
+		"ambient":     sandbox.CapabilityNames(capabilities.Ambient),
+	}
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// defaultEnvironment is given to every service, services don't inherit the
+// environment of the daemon unless it's listed in pass_environment. An empty
+// home is the home directory of the daemon user.
+func defaultEnvironment(home string) []string {
+	if home == "" {
+		var err error
+		if home, err = os.UserHomeDir(); err != nil {
+			home = "/"
+		}
+	}
+	lang := os.Getenv("LANG")
+	if lang == "" {
+		lang = "C.UTF-8"
+	}
+	return []string{"PATH=" + defaultPath, "HOME=" + home, "LANG=" + lang}
+}
+
+// buildEnvironment resolves the environment of a service in order of
+// precedence: defaults, pass_environment, env_file entries, env entries and
+// extra. Values may reference earlier variables with ${VAR}.
+func buildEnvironment(home string, passEnv []string, envFiles []string, env []string, extra []string) (*environment, error) {
+	environment := newEnvironment(defaultEnvironment(home))
+
+	for _, name := range passEnv {
+		if value, exists := os.LookupEnv(name); exists {
+			environment.set(name, value)
+		}
+	}
+
+	for _, path := range envFiles {
+		// Like systemd, a "-" prefix ignores missing files
+		optional := strings.HasPrefix(path, "-")
+		path = strings.TrimPrefix(path, "-")
+		err := readEnvFile(path, environment)
+		if err != nil && !(optional && os.IsNotExist(err)) {
+			return nil, fmt.Errorf("failed to read env file %s: %v", path, err)
+		}
+	}
+
+	entries := append(append([]string{}, env...), extra...)
+	for _, entry := range entries {
+		name, value, found := strings.Cut(entry, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid environment variable: %q", entry)
+		}
+		value, secret := environment.expandSecret(value)
+		environment.set(name, value)
+		environment.secret[name] = secret
+	}
+
+	return environment, nil
+}
+
+// maskedValue replaces values that came from an env_file in status
+const maskedValue = "<from env_file>"
+
+// environment keeps the variables in the order they were first defined.
+// Values read from an env_file, or expanded from one, are secret.
+type environment struct {
+	names  []string
+	values map[string]string
+	secret map[string]bool
+}
+
+func newEnvironment(entries []string) *environment {
+	e := &environment{values: make(map[string]string), secret: make(map[string]bool)}
+	for _, entry := range entries {
+		name, value, _ := strings.Cut(entry, "=")
+		e.set(name, value)
+	}
+	return e
+}
+
+func (e *environment) set(name string, value string) {
+	if _, exists := e.values[name]; !exists {
+		e.names = append(e.names, name)
+	}
+	e.values[name] = value
+}
+
+func (e *environment) list() []string {
+	entries := make([]string, len(e.names))
+	for i, name := range e.names {
+		entries[i] = name + "=" + e.values[name]
+	}
+	return entries
+}
+
+// masked lists the variables like list with secret values replaced
+func (e *environment) masked() []string {
+	entries := e.list()
+	for i, name := range e.names {
+		if e.secret[name] {
+			entries[i] = name + "=" + maskedValue
+		}
+	}
+	return entries
+}
+
+// expand replaces ${VAR} with the value of VAR and $$ with $. Unknown
+// variables expand to an empty string.
+func (e *environment) expand(value string) string {
+	expanded, _ := e.expandSecret(value)
+	return expanded
+}
+
+// expandSecret is expand that also reports whether a secret was referenced
+func (e *environment) expandSecret(value string) (string, bool) {
+	secret := false
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 >= len(value) {
+			sb.WriteByte(value[i])
+			continue
+		}
+		switch value[i+1] {
+		case '$':
+			sb.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 {
+				sb.WriteByte(value[i])
+				continue
+			}
+			name := value[i+2 : i+2+end]
+			sb.WriteString(e.values[name])
+			secret = secret || e.secret[name]
+			i += end + 2
+		default:
+			sb.WriteByte(value[i])
+		}
+	}
+	return sb.String(), secret
+}
+
+// expandArguments replaces ${VAR} in each argument
+func expandArguments(args []string, env []string) []string {
+	environment := newEnvironment(env)
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = environment.expand(arg)
+	}
+	return expanded
+}
+
+// readEnvFile adds the variables of a file in dotenv syntax:
+//
+//	# comment
+//	export NAME=value
+//	NAME='literal $value'
+//	NAME="expanded ${OTHER}\n"
+//	NAME=unquoted value # comment
+func readEnvFile(path string, environment *environment) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, rawValue, found := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("line %d: expected NAME=value", lineNumber)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(rawValue), environment)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		environment.set(name, value)
+		environment.secret[name] = true
+	}
+	return scanner.Err()
+}
+
+func parseEnvValue(value string, environment *environment) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch value[0] {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		return value[1 : end+1], nil
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch value[i] {
+			case '\\':
+				if i+1 >= len(value) {
+					return "", fmt.Errorf("unterminated double quote")
+				}
+				i++
+				switch value[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(value[i])
+				}
+			case '"':
+				return environment.expand(sb.String()), nil
+			default:
+				sb.WriteByte(value[i])
+			}
+		}
+		return "", fmt.Errorf("unterminated double quote")
+	}
+
+	if comment := strings.Index(value, " #"); comment >= 0 {
+		value = strings.TrimSpace(value[:comment])
+	}
+	return environment.expand(value), nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "comments and blank lines",
+			content: "# comment\n\nA=1\n  # indented comment\nB=2\n",
+			want:    []string{"BASE=base", "A=1", "B=2"},
+		},
+		{
+			name:    "export prefix",
+			content: "export A=1\n",
+			want:    []string{"BASE=base", "A=1"},
+		},
+		{
+			name:    "single quotes are literal",
+			content: "A='literal ${BASE} $$'\n",
+			want:    []string{"BASE=base", "A=literal ${BASE} $$"},
+		},
+		{
+			name:    "double quotes expand and unescape",
+			content: `A="${BASE}\n\t\"x\""` + "\n",
+			want:    []string{"BASE=base", "A=base\n\t\"x\""},
+		},
+		{
+			name:    "unquoted value with a comment",
+			content: "A=${BASE}/bin # comment\n",
+			want:    []string{"BASE=base", "A=base/bin"},
+		},
+		{
+			name:    "hash without space belongs to the value",
+			content: "A=a#b\n",
+			want:    []string{"BASE=base", "A=a#b"},
+		},
+		{
+			name:    "later lines see earlier ones",
+			content: "A=1\nB=${A}2\nA=3\n",
+			want:    []string{"BASE=base", "A=3", "B=12"},
+		},
+		{
+			name:    "empty value",
+			content: "A=\n",
+			want:    []string{"BASE=base", "A="},
+		},
+		{
+			name:    "missing equals sign",
+			content: "A=1\nB\n",
+			wantErr: "line 2: expected NAME=value",
+		},
+		{
+			name:    "space in the name",
+			content: "A B=1\n",
+			wantErr: "line 1: expected NAME=value",
+		},
+		{
+			name:    "unterminated single quote",
+			content: "A='x\n",
+			wantErr: "line 1: unterminated single quote",
+		},
+		{
+			name:    "unterminated double quote",
+			content: "A=\"x\n",
+			wantErr: "line 1: unterminated double quote",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "env")
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			environment := newEnvironment([]string{"BASE=base"})
+			err := readEnvFile(path, environment)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("readEnvFile() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readEnvFile() error = %v", err)
+			}
+			if got := environment.list(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("readEnvFile() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.env")
+	second := filepath.Join(dir, "second.env")
+	os.WriteFile(first, []byte("FILE=first\nSHARED=first\n"), 0600)
+	os.WriteFile(second, []byte("SHARED=second\nFROM_FILE=${FILE}\n"), 0600)
+	t.Setenv("OPS_CTRL_TEST_PASSED", "passed")
+	t.Setenv("LANG", "en_US.UTF-8")
+
+	tests := []struct {
+		name     string
+		passEnv  []string
+		envFiles []string
+		env      []string
+		extra    []string
+		want     []string
+		wantErr  string
+	}{
+		{
+			name: "defaults only",
+			want: []string{"PATH=" + defaultPath, "HOME=/home/test", "LANG=en_US.UTF-8"},
+		},
+		{
+			name:    "pass_environment",
+			passEnv: []string{"OPS_CTRL_TEST_PASSED", "OPS_CTRL_TEST_UNSET"},
+			want:    []string{"PATH=" + defaultPath, "HOME=/home/test", "LANG=en_US.UTF-8", "OPS_CTRL_TEST_PASSED=passed"},
+		},
+		{
+			name:     "later files override earlier ones",
+			envFiles: []string{first, second},
+			want:     []string{"PATH=" + defaultPath, "HOME=/home/test", "LANG=en_US.UTF-8", "FILE=first", "SHARED=second", "FROM_FILE=first"},
+		},
+		{
+			name:     "env overrides files and keeps the first position",
+			envFiles: []string{first},
+			env:      []string{"SHARED=env", "PATH=/bin:${PATH}"},
+			want:     []string{"PATH=/bin:" + defaultPath, "HOME=/home/test", "LANG=en_US.UTF-8", "FILE=first", "SHARED=env"},
+		},
+		{
+			name:  "extra overrides env",
+			env:   []string{"NOTIFY_SOCKET=env"},
+			extra: []string{"NOTIFY_SOCKET=extra"},
+			want:  []string{"PATH=" + defaultPath, "HOME=/home/test", "LANG=en_US.UTF-8", "NOTIFY_SOCKET=extra"},
+		},
+		{
+			name: "dollar escape and unknown variables",
+			env:  []string{"A=$${HOME}", "B=${UNKNOWN}x"},
+			want: []string{"PATH=" + defaultPath, "HOME=/home/test", "LANG=en_US.UTF-8", "A=${HOME}", "B=x"},
+		},
+		{
+			name:     "optional missing file",
+			envFiles: []string{"-" + filepath.Join(dir, "missing.env")},
+			want:     []string{"PATH=" + defaultPath, "HOME=/home/test", "LANG=en_US.UTF-8"},
+		},
+		{
+			name:     "required missing file",
+			envFiles: []string{filepath.Join(dir, "missing.env")},
+			wantErr:  "failed to read env file",
+		},
+		{
+			name:    "entry without a name",
+			env:     []string{"=value"},
+			wantErr: "invalid environment variable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			environment, err := buildEnvironment("/home/test", test.passEnv, test.envFiles, test.env, test.extra)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("buildEnvironment() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildEnvironment() error = %v", err)
+			}
+			if got := environment.list(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("buildEnvironment() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMaskedEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.env")
+	os.WriteFile(path, []byte("TOKEN=secret\nOVERRIDDEN=secret\n"), 0600)
+	t.Setenv("LANG", "en_US.UTF-8")
+
+	environment, err := buildEnvironment("/home/test", nil, []string{path},
+		[]string{"OVERRIDDEN=plain", "URL=https://${TOKEN}@host", "PLAIN=${HOME}/x"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"PATH=" + defaultPath, "HOME=/home/test", "LANG=en_US.UTF-8",
+		"TOKEN=" + maskedValue, "OVERRIDDEN=plain", "URL=" + maskedValue, "PLAIN=/home/test/x",
+	}
+	if got := environment.masked(); !reflect.DeepEqual(got, want) {
+		t.Errorf("masked() = %q, want %q", got, want)
+	}
+}
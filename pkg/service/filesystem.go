@@ -0,0 +1,48 @@
+package service
+
+import (
+	"fmt"
+
+	"ops-ctrl/pkg/sandbox"
+)
+
+// filesystem returns the filesystem sandbox of the main process, nil without
+// one
+func (o Options) filesystem() (*sandbox.Filesystem, error) {
+	if !o.PrivateTmp && len(o.ReadOnlyPaths) == 0 && len(o.InaccessiblePaths) == 0 && len(o.BindPaths) == 0 &&
+		o.RootDirectory == "" && (o.ProtectSystem == "" || o.ProtectSystem == "no") {
+		return nil, nil
+	}
+
+	filesystem := &sandbox.Filesystem{
+		Root:          o.RootDirectory,
+		ProtectSystem: o.ProtectSystem,
+		ReadOnly:      o.ReadOnlyPaths,
+		Inaccessible:  o.InaccessiblePaths,
+		PrivateTmp:    o.PrivateTmp,
+	}
+	for _, value := range o.BindPaths {
+		bind, err := sandbox.ParseBind(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bind_paths: %v", err)
+		}
+		filesystem.Binds = append(filesystem.Binds, bind)
+	}
+	if o.Type == TypeNotify || o.WatchdogSec > 0 {
+		// $NOTIFY_SOCKET must stay reachable behind a private /tmp or root
+		filesystem.Keep = []string{NotifyDir}
+	}
+	if err := filesystem.Validate(); err != nil {
+		return nil, err
+	}
+	return filesystem, nil
+}
+
+// namespaceList returns the namespaces of the main process, the filesystem
+// sandbox is set up in a mount namespace
+func (o Options) namespaceList() []string {
+	if filesystem, _ := o.filesystem(); filesystem == nil || o.hasNamespace("mount") {
+		return o.Namespaces
+	}
+	return append(o.Namespaces[:len(o.Namespaces):len(o.Namespaces)], "mount")
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"ops-ctrl/pkg/sandbox"
+)
+
+// helperVariable passes the helper spec to the daemon binary started as exec
+// helper, see RunHelper
+const helperVariable = "OPS_CTRL_EXEC_HELPER"
+
+// helperBinary is the daemon binary, even after it was replaced on disk
+const helperBinary = "/proc/self/exe"
+
+// helperStatusFD is the status pipe of the exec helper, the first of the
+// extra files
+const helperStatusFD = 3
+
+// helperSpec is what the exec helper does in the new process before it
+// executes the service binary
+type helperSpec struct {
+	PrivateMounts bool                   `json:"private_mounts,omitempty"` // Keep mounts of the mount namespace from the host
+	Filesystem    *sandbox.Filesystem    `json:"filesystem,omitempty"`     // Set up in the mount namespace
+	MountProc     bool                   `json:"mount_proc,omitempty"`     // Mount /proc of the PID namespace
+	WorkingDir    string                 `json:"working_dir,omitempty"`    // Changed to inside the root directory
+	Hostname      string                 `json:"hostname,omitempty"`       // Host name of the uts namespace
+	Loopback      bool                   `json:"loopback,omitempty"`       // Bring up lo in the network namespace
+	DropBounding  []uintptr              `json:"drop_bounding,omitempty"`  // Capabilities dropped from the bounding set
+	Scheduling    *sandbox.Scheduling    `json:"scheduling,omitempty"`     // Set before the user changes, raising priorities needs privileges
+	Credential    *syscall.Credential    `json:"credential,omitempty"`     // Changed after the steps that need privileges
+	AmbientCaps   []uintptr              `json:"ambient_caps,omitempty"`   // Raised after the user changed
+	NoNewPrivs    bool                   `json:"no_new_privs,omitempty"`
+	Landlock      *sandbox.Landlock      `json:"landlock,omitempty"`       // Applied after the root directory and user changed
+	SyscallFilter *sandbox.SyscallFilter `json:"syscall_filter,omitempty"` // Installed last, right before the exec
+	WatchdogPID   bool                   `json:"watchdog_pid,omitempty"`   // Export $WATCHDOG_PID, only known after the fork
+	StatusFD      int                    `json:"status_fd,omitempty"`      // Closed on exec, errors are written to it for the type exec
+}
+
+// helperStatus is the status pipe of the running exec helper, nil without it
+var helperStatus *os.File
+
+// helperSpec returns nil when the service binary can be executed directly
+func (p *Process) helperSpec() *helperSpec {
+	spec := &helperSpec{
+		Filesystem:    p.filesystem,
+		Hostname:      p.hostname,
+		DropBounding:  p.boundingDrops,
+		NoNewPrivs:    p.noNewPrivs,
+		Scheduling:    p.scheduling,
+		Landlock:      p.landlock,
+		SyscallFilter: p.syscallFilter,
+		WatchdogPID:   p.watchdog,
+	}
+	if p.filesystem != nil && p.filesystem.Root != "" {
+		spec.WorkingDir = p.workingDir
+	}
+	newPID := false
+	for _, name := range p.namespaces {
+		switch name {
+		case "mount":
+			spec.PrivateMounts = true
+		case "net":
+			spec.Loopback = true
+		case "pid":
+			newPID = true
+		}
+	}
+	spec.MountProc = spec.PrivateMounts && newPID
+	if !spec.PrivateMounts && spec.Hostname == "" && !spec.Loopback && len(spec.DropBounding) == 0 && !spec.NoNewPrivs && !spec.WatchdogPID &&
+		spec.Scheduling == nil && spec.Landlock == nil && spec.SyscallFilter == nil {
+		return nil
+	}
+	return spec
+}
+
+// RunHelper executes the service binary when the daemon binary was started
+// as exec helper by Process.Start and returns otherwise. It must be called
+// first in main.
+func RunHelper() {
+	value, isHelper := os.LookupEnv(helperVariable)
+	if !isHelper {
+		return
+	}
+	os.Unsetenv(helperVariable)
+	// Capabilities, the bounding set and no_new_privs belong to the thread
+	// that executes the binary
+	runtime.LockOSThread()
+	if len(os.Args) < 2 {
+		helperFailed(fmt.Errorf("no binary to execute"))
+	}
+
+	var spec helperSpec
+	if err := json.Unmarshal([]byte(value), &spec); err != nil {
+		helperFailed(fmt.Errorf("invalid spec: %v", err))
+	}
+	if spec.StatusFD > 0 {
+		syscall.CloseOnExec(spec.StatusFD)
+		helperStatus = os.NewFile(uintptr(spec.StatusFD), "status pipe")
+	}
+	if spec.PrivateMounts {
+		if err := sandbox.PrivateMounts(); err != nil {
+			helperFailed(err)
+		}
+	}
+	root := "/"
+	if spec.Filesystem != nil {
+		if err := spec.Filesystem.Apply(); err != nil {
+			helperFailed(err)
+		}
+		if spec.Filesystem.Root != "" {
+			root = spec.Filesystem.Root
+		}
+	}
+	if spec.MountProc {
+		if err := sandbox.MountProc(filepath.Join(root, "proc")); err != nil {
+			helperFailed(err)
+		}
+	}
+	if spec.Hostname != "" {
+		if err := syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+			helperFailed(fmt.Errorf("failed to set hostname: %v", err))
+		}
+	}
+	if spec.Loopback {
+		if err := sandbox.Loopback(); err != nil {
+			helperFailed(err)
+		}
+	}
+	if root != "/" {
+		if err := sandbox.Chroot(root, spec.WorkingDir); err != nil {
+			helperFailed(err)
+		}
+	}
+	if err := sandbox.DropBounding(spec.DropBounding); err != nil {
+		helperFailed(err)
+	}
+	if spec.Scheduling != nil {
+		if err := spec.Scheduling.Apply(); err != nil {
+			helperFailed(err)
+		}
+	}
+	if spec.Credential != nil {
+		if len(spec.AmbientCaps) > 0 {
+			if err := sandbox.KeepCapabilities(); err != nil {
+				helperFailed(err)
+			}
+		}
+		if err := changeCredential(*spec.Credential); err != nil {
+			helperFailed(err)
+		}
+	}
+	if len(spec.AmbientCaps) > 0 {
+		if err := sandbox.RaiseAmbient(spec.AmbientCaps); err != nil {
+			helperFailed(err)
+		}
+	}
+	if spec.NoNewPrivs {
+		if err := sandbox.NoNewPrivileges(); err != nil {
+			helperFailed(err)
+		}
+	}
+	if spec.WatchdogPID {
+		os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	}
+	environment := os.Environ()
+	if spec.Landlock != nil {
+		if err := spec.Landlock.Apply(); err != nil {
+			helperFailed(err)
+		}
+	}
+
+	// Everything exec needs is prepared before the filter is installed, so
+	// nothing allocates in between
+	binary, err := syscall.BytePtrFromString(os.Args[1])
+	if err != nil {
+		helperFailed(fmt.Errorf("invalid binary: %v", err))
+	}
+	argv, err := syscall.SlicePtrFromStrings(os.Args[1:])
+	if err != nil {
+		helperFailed(fmt.Errorf("invalid arguments: %v", err))
+	}
+	envv, err := syscall.SlicePtrFromStrings(environment)
+	if err != nil {
+		helperFailed(fmt.Errorf("invalid environment: %v", err))
+	}
+	if spec.SyscallFilter != nil {
+		if err := spec.SyscallFilter.Install(); err != nil {
+			helperFailed(err)
+		}
+	}
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_EXECVE,
+		uintptr(unsafe.Pointer(binary)), uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+	helperFailed(fmt.Errorf("failed to execute %s: %v", os.Args[1], errno))
+}
+
+// changeCredential switches to the user and groups of the service like
+// exec.Cmd does with SysProcAttr.Credential
+func changeCredential(credential syscall.Credential) error {
+	if !credential.NoSetGroups {
+		groups := make([]int, len(credential.Groups))
+		for i, group := range credential.Groups {
+			groups[i] = int(group)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("failed to set groups: %v", err)
+		}
+	}
+	if err := syscall.Setgid(int(credential.Gid)); err != nil {
+		return fmt.Errorf("failed to set group: %v", err)
+	}
+	if err := syscall.Setuid(int(credential.Uid)); err != nil {
+		return fmt.Errorf("failed to set user: %v", err)
+	}
+	return nil
+}
+
+// helperFailed ends the helper with the exit code of a shell that couldn't
+// execute a command, the message ends up in the service output and the
+// status pipe
+func helperFailed(err error) {
+	fmt.Fprintf(os.Stderr, "ops-ctrl: %v\n", err)
+	if helperStatus != nil {
+		helperStatus.WriteString(err.Error())
+	}
+	os.Exit(127)
+}
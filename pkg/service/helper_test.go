@@ -0,0 +1,60 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"ops-ctrl/pkg/sandbox"
+)
+
+// TestMain lets the test binary act as the exec helper, like the daemon does
+func TestMain(m *testing.M) {
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+func TestHelperSyscallFilter(t *testing.T) {
+	if _, err := os.Stat("/bin/true"); err != nil {
+		t.Skip("no /bin/true")
+	}
+	tests := []struct {
+		name       string
+		allow      []string
+		wantCode   int
+		wantSignal syscall.Signal
+	}{
+		// The allow lists lack most of what the Go runtime uses, the helper
+		// still has to get to exec
+		{name: "binary allowed", allow: []string{"@default", "@file-system", "@basic-io"}, wantCode: 0},
+		{name: "binary killed", allow: []string{"@basic-io"}, wantCode: -1, wantSignal: syscall.SIGSYS},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			process := NewProcess(Options{
+				Binary:           "/bin/true",
+				Type:             TypeExec,
+				SystemCallAllow:  test.allow,
+				SystemCallAction: sandbox.ActionKill,
+			})
+			if err := process.Start(); err != nil {
+				if strings.Contains(err.Error(), "seccomp") {
+					t.Skipf("seccomp unavailable: %v", err)
+				}
+				t.Fatalf("Start() = %v", err)
+			}
+			select {
+			case <-process.Exited():
+			case <-time.After(10 * time.Second):
+				process.Signal(syscall.SIGKILL)
+				t.Fatal("process didn't exit")
+			}
+			if code, signal := process.ExitCode(), process.ExitSignal(); code != test.wantCode || signal != test.wantSignal {
+				t.Errorf("exit code %d, signal %v, want %d, %v", code, signal, test.wantCode, test.wantSignal)
+			}
+		})
+	}
+}
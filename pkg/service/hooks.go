@@ -0,0 +1,222 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// hook names a group of hook commands, like the option in config.toml
+type hook string
+
+const (
+	hookStartPre  hook = "exec_start_pre"
+	hookStartPost hook = "exec_start_post"
+	hookStop      hook = "exec_stop"
+	hookStopPost  hook = "exec_stop_post"
+	hookReload    hook = "exec_reload"
+)
+
+// hooks returns the commands of a hook
+func (o Options) hooks(name hook) []string {
+	switch name {
+	case hookStartPre:
+		return o.ExecStartPre
+	case hookStartPost:
+		return o.ExecStartPost
+	case hookStop:
+		return o.ExecStop
+	case hookStopPost:
+		return o.ExecStopPost
+	case hookReload:
+		return o.ExecReload
+	}
+	return nil
+}
+
+// validateHooks checks that every hook command can be parsed and that its
+// binary exists, unless it's only known once the environment is expanded
+func (o Options) validateHooks() error {
+	for _, name := range []hook{hookStartPre, hookStartPost, hookStop, hookStopPost, hookReload} {
+		for _, line := range o.hooks(name) {
+			words, _, err := parseHook(line)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %v", name, line, err)
+			}
+			if strings.Contains(words[0], "$") {
+				continue
+			}
+			if _, err := ResolveBinary(words[0]); err != nil {
+				return fmt.Errorf("invalid %s %q: %v", name, line, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseHook splits a hook command line into words. A leading "-" means a
+// failure of the command is ignored.
+func parseHook(line string) (words []string, ignoreFailure bool, err error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "-") {
+		ignoreFailure = true
+		line = line[1:]
+	}
+	words, err = splitCommand(line)
+	if err == nil && len(words) == 0 {
+		err = fmt.Errorf("empty command")
+	}
+	return words, ignoreFailure, err
+}
+
+// splitCommand splits at whitespace outside of single and double quotes, a
+// backslash escapes the next character outside of single quotes
+func splitCommand(line string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, char := range line {
+		switch {
+		case escaped:
+			word.WriteRune(char)
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				word.WriteRune(char)
+			}
+		case char == '\'' || char == '"':
+			quote = char
+			inWord = true
+		case char == ' ' || char == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(char)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// runHooks runs the commands of a hook one after another and stops at the
+// first failure that isn't ignored. The main PID is passed as $MAINPID.
+func (s *Service) runHooks(name hook) error {
+	extraEnv := []string{}
+	if pid := s.GetPID(); pid != 0 && s.Process.Status() == "running" {
+		extraEnv = append(extraEnv, fmt.Sprintf("MAINPID=%d", pid))
+	}
+	if s.notify != nil {
+		extraEnv = append(extraEnv, "NOTIFY_SOCKET="+s.notify.path)
+	}
+
+	for _, line := range s.Options.hooks(name) {
+		words, ignoreFailure, err := parseHook(line)
+		if err == nil {
+			err = s.Process.runHook(words, extraEnv, s.Options.HookTimeout)
+		}
+		if err == nil {
+			continue
+		}
+		if ignoreFailure {
+			fmt.Printf("Service %s: ignoring failed %s %q: %v\n", s.ID, name, line, err)
+			continue
+		}
+		return fmt.Errorf("%s %q failed: %v", name, line, err)
+	}
+	return nil
+}
+
+// runHook runs a hook command with the environment, user and working
+// directory of the service. Its output is added to the service output.
+func (p *Process) runHook(words []string, extraEnv []string, timeout time.Duration) error {
+	p.mu.Lock()
+	resolved, err := p.buildEnvironment(extraEnv)
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	environment := resolved.list()
+	words = expandArguments(words, environment)
+	binary, err := ResolveBinary(words[0])
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+
+	var logFile *os.File
+	if p.logPath != "" {
+		logFile, err = os.OpenFile(p.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			p.mu.Unlock()
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		defer logFile.Close()
+	}
+	if p.outputBuffer == nil {
+		p.outputBuffer = &bytes.Buffer{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, binary, words[1:]...)
+	cmd.Dir = p.workingDir
+	cmd.Env = environment
+	cmd.SysProcAttr = p.sysProcAttr()
+	cmd.Stdout = &outputWriter{process: p, logFile: logFile}
+	cmd.Stderr = cmd.Stdout
+	// Daemonized children of the hook may keep the output open
+	cmd.WaitDelay = time.Second
+
+	err = startChild(cmd)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Wait()
+	releaseChild(cmd.Process.Pid)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	return err
+}
+
+// outputWriter adds to the captured output and the log file like the output
+// of the main process
+type outputWriter struct {
+	process *Process
+	logFile *os.File
+}
+
+func (w *outputWriter) Write(chunk []byte) (int, error) {
+	w.process.mu.Lock()
+	w.process.outputBuffer.Write(chunk)
+	w.process.mu.Unlock()
+	if w.logFile != nil {
+		w.logFile.Write(chunk)
+	}
+	return len(chunk), nil
+}
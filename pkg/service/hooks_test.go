@@ -0,0 +1,50 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHook(t *testing.T) {
+	tests := []struct {
+		line          string
+		want          []string
+		ignoreFailure bool
+		wantErr       string
+	}{
+		{line: "/bin/true", want: []string{"/bin/true"}},
+		{line: "  /bin/echo  a\tb  ", want: []string{"/bin/echo", "a", "b"}},
+		{line: "-/bin/false", want: []string{"/bin/false"}, ignoreFailure: true},
+		{line: "  -/bin/false x", want: []string{"/bin/false", "x"}, ignoreFailure: true},
+		{line: "/bin/echo -n x", want: []string{"/bin/echo", "-n", "x"}},
+		{line: `/bin/sh -c 'echo "$MAINPID"'`, want: []string{"/bin/sh", "-c", `echo "$MAINPID"`}},
+		{line: `/bin/echo "a b" 'c d'`, want: []string{"/bin/echo", "a b", "c d"}},
+		{line: `/bin/echo a\ b`, want: []string{"/bin/echo", "a b"}},
+		{line: `/bin/echo "say \"hi\""`, want: []string{"/bin/echo", `say "hi"`}},
+		{line: `/bin/echo 'back\slash'`, want: []string{"/bin/echo", `back\slash`}},
+		{line: `/bin/echo "" ''`, want: []string{"/bin/echo", "", ""}},
+		{line: `/bin/echo pre"fix"'ed'`, want: []string{"/bin/echo", "prefixed"}},
+		{line: "", wantErr: "empty command"},
+		{line: "-", ignoreFailure: true, wantErr: "empty command"},
+		{line: `/bin/echo "open`, wantErr: "unterminated quote"},
+		{line: `/bin/echo 'open`, wantErr: "unterminated quote"},
+		{line: `/bin/echo a\`, wantErr: "trailing backslash"},
+	}
+
+	for _, test := range tests {
+		words, ignoreFailure, err := parseHook(test.line)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("parseHook(%q) error = %v, want %q", test.line, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHook(%q) error = %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(words, test.want) || ignoreFailure != test.ignoreFailure {
+			t.Errorf("parseHook(%q) = %q, %v, want %q, %v", test.line, words, ignoreFailure, test.want, test.ignoreFailure)
+		}
+	}
+}
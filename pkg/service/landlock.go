@@ -0,0 +1,35 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"ops-ctrl/pkg/sandbox"
+)
+
+// landlock returns the Landlock ruleset of the main process, nil without
+// one. The binary is always executable, inside the root directory if set.
+func (o Options) landlock() (*sandbox.Landlock, error) {
+	if len(o.LandlockReadPaths) == 0 && len(o.LandlockWritePaths) == 0 && len(o.LandlockExecutePaths) == 0 {
+		if o.LandlockMode != "" {
+			return nil, fmt.Errorf("landlock_mode requires landlock paths")
+		}
+		return nil, nil
+	}
+	landlock := &sandbox.Landlock{
+		Read:    o.LandlockReadPaths,
+		Write:   o.LandlockWritePaths,
+		Execute: o.LandlockExecutePaths,
+		Mode:    o.LandlockMode,
+	}
+	if err := landlock.Validate(); err != nil {
+		return nil, err
+	}
+	if landlock.Mode == sandbox.LandlockRequired && sandbox.LandlockABI() == 0 {
+		return nil, fmt.Errorf("landlock_mode is required but the kernel doesn't support Landlock")
+	}
+	if filepath.IsAbs(o.Binary) {
+		landlock.Execute = append(landlock.Execute[:len(landlock.Execute):len(landlock.Execute)], o.Binary)
+	}
+	return landlock, nil
+}
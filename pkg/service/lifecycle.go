@@ -0,0 +1,228 @@
+package service
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"ops-ctrl/pkg/events"
+)
+
+// killTimeout is how long to wait for the process to disappear after SIGKILL
+const killTimeout = 5 * time.Second
+
+// supervise waits for the process of one run to exit, records how it ended
+// and applies the restart policy
+func (s *Service) supervise(generation int) {
+	<-s.Process.Exited()
+	code := s.Process.ExitCode()
+	signal := s.Process.ExitSignal()
+
+	s.mu.Lock()
+	if s.generation != generation {
+		// Stopped or restarted on request
+		s.mu.Unlock()
+		return
+	}
+	clean := code == 0 || cleanSignal(signal)
+	detail := fmt.Sprintf("exited with code %d", code)
+	if signal != 0 {
+		detail = fmt.Sprintf("killed by %v", signal)
+	}
+	if s.failure != "" {
+		// Ended by the daemon, like a missed watchdog ping
+		clean = false
+		detail = s.failure + ", " + detail
+		s.failure = ""
+	}
+	restart := s.Options.Restart == RestartAlways || (s.Options.Restart == RestartOnFailure && !clean)
+
+	kind := events.Failed
+	switch {
+	case restart:
+		s.Restarts++
+		s.Status = NewServiceStatus("restarting", detail)
+		kind = events.Restarting
+	case clean:
+		s.Status = NewServiceStatus("exited", detail)
+		kind = events.Exited
+	default:
+		s.Status = NewServiceStatus("failed", detail)
+	}
+	s.mu.Unlock()
+
+	s.emit(kind, detail)
+	fmt.Printf("Service %s %s\n", s.ID, detail)
+
+	s.lifecycle.Lock()
+	if !s.isStale(generation) {
+		s.runStopPost()
+	}
+	s.lifecycle.Unlock()
+	if !restart {
+		return
+	}
+
+	time.Sleep(s.Options.RestartSec)
+
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	if s.isStale(generation) {
+		return
+	}
+	if err := s.start(); err != nil {
+		fmt.Printf("Service %s failed to restart: %v\n", s.ID, err)
+	}
+}
+
+// isStale reports if the service was started or stopped since generation
+func (s *Service) isStale(generation int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation != generation
+}
+
+// runStopPost runs the post-stop hooks, their failures are only reported
+func (s *Service) runStopPost() {
+	if err := s.runHooks(hookStopPost); err != nil {
+		fmt.Printf("Service %s: %v\n", s.ID, err)
+	}
+}
+
+// cleanSignal reports if a death by signal counts as a clean exit, like
+// systemd these are the signals used to ask a process to terminate
+func cleanSignal(signal syscall.Signal) bool {
+	switch signal {
+	case syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGPIPE:
+		return true
+	}
+	return false
+}
+
+// Stop sends SIGTERM and escalates to SIGKILL when the process is still
+// running after timeout. A zero timeout uses the stop_timeout of the service.
+func (s *Service) Stop(timeout time.Duration) error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	return s.stop(timeout)
+}
+
+func (s *Service) stop(timeout time.Duration) error {
+	s.mu.Lock()
+	// Cancels a pending automatic restart
+	s.generation++
+	state := s.Status.State
+	s.mu.Unlock()
+
+	if s.Process.Status() != "running" {
+		if state == "restarting" {
+			s.setStatus("stopped", "automatic restart canceled")
+			s.emit(events.Stopped, "automatic restart canceled")
+		}
+		return nil
+	}
+
+	if timeout <= 0 {
+		timeout = s.Options.StopTimeout
+	}
+	s.setStatus("stopping")
+	exited := s.Process.Exited()
+
+	// Stop hooks ask the service to stop, SIGTERM follows if they didn't succeed
+	if len(s.Options.ExecStop) > 0 {
+		if err := s.runHooks(hookStop); err != nil {
+			fmt.Printf("Service %s: %v\n", s.ID, err)
+		}
+		select {
+		case <-exited:
+			s.setStatus("stopped", "stopped by exec_stop")
+			s.emit(events.Stopped, "stopped by exec_stop")
+			s.runStopPost()
+			return nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	if err := s.Process.Signal(syscall.SIGTERM); err != nil && s.Process.Status() == "running" {
+		s.setStatus("error", fmt.Sprintf("failed to stop: %v", err))
+		return err
+	}
+
+	select {
+	case <-exited:
+		s.setStatus("stopped", "terminated with SIGTERM")
+		s.emit(events.Stopped, "terminated with SIGTERM")
+		s.runStopPost()
+		return nil
+	case <-time.After(timeout):
+	}
+
+	fmt.Printf("Service %s did not stop in %v, sending SIGKILL\n", s.ID, timeout)
+	s.Process.Signal(syscall.SIGKILL)
+	select {
+	case <-exited:
+		s.setStatus("stopped", fmt.Sprintf("killed with SIGKILL after %v", timeout))
+		s.emit(events.Stopped, fmt.Sprintf("killed with SIGKILL after %v", timeout))
+		s.runStopPost()
+		return nil
+	case <-time.After(killTimeout):
+		s.setStatus("error", "process did not exit after SIGKILL")
+		return fmt.Errorf("process %d did not exit after SIGKILL", s.GetPID())
+	}
+}
+
+// Reload runs the reload hooks of a running service
+func (s *Service) Reload() error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	if len(s.Options.ExecReload) == 0 {
+		return fmt.Errorf("service %s has no exec_reload", s.ID)
+	}
+	if s.State() != "running" {
+		return fmt.Errorf("service %s is not running", s.ID)
+	}
+	if err := s.runHooks(hookReload); err != nil {
+		return err
+	}
+	s.emit(events.Reloaded, "")
+	return nil
+}
+
+// Restart stops the service if it is running and starts it again with a new
+// process
+func (s *Service) Restart(timeout time.Duration) error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	if err := s.stop(timeout); err != nil {
+		return err
+	}
+	return s.start()
+}
+
+// TryRestart restarts the service only if it is running, restarted is false
+// when nothing was done
+func (s *Service) TryRestart(timeout time.Duration) (restarted bool, err error) {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	if s.State() != "running" {
+		return false, nil
+	}
+	if err := s.stop(timeout); err != nil {
+		return false, err
+	}
+	return true, s.start()
+}
+
+// Close releases the resources of a stopped service
+func (s *Service) Close() {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	if s.notify != nil {
+		s.notify.Close()
+		s.notify = nil
+	}
+}
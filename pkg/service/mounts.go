@@ -0,0 +1,13 @@
+package service
+
+// RequireMounts mounts the filesystems the paths are on, it's set by the
+// daemon once the mount table is known. Services can start without it.
+var RequireMounts func(paths []string) error
+
+// requireMounts is called before every start with requires_mounts_for
+func requireMounts(paths []string) error {
+	if len(paths) == 0 || RequireMounts == nil {
+		return nil
+	}
+	return RequireMounts(paths)
+}
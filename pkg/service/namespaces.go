@@ -0,0 +1,151 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"ops-ctrl/pkg/procfs"
+)
+
+// namespaceKinds maps the names of the namespaces option to their clone flag
+// and their name in /proc/<pid>/ns
+var namespaceKinds = map[string]struct {
+	flag uintptr
+	proc string
+}{
+	"pid":   {syscall.CLONE_NEWPID, "pid"},
+	"mount": {syscall.CLONE_NEWNS, "mnt"},
+	"uts":   {syscall.CLONE_NEWUTS, "uts"},
+	"ipc":   {syscall.CLONE_NEWIPC, "ipc"},
+	"net":   {syscall.CLONE_NEWNET, "net"},
+	"user":  {syscall.CLONE_NEWUSER, "user"},
+}
+
+// hasNamespace reports if the main process gets a new namespace of a kind
+func (o Options) hasNamespace(kind string) bool {
+	for _, name := range o.Namespaces {
+		if name == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Options) validateNamespaces() error {
+	for _, name := range o.Namespaces {
+		if _, exists := namespaceKinds[name]; !exists {
+			return fmt.Errorf("invalid namespace: %s", name)
+		}
+	}
+	if o.Hostname != "" && !o.hasNamespace("uts") {
+		return fmt.Errorf("hostname requires a uts namespace")
+	}
+	if (len(o.UIDMap) > 0 || len(o.GIDMap) > 0) && !o.hasNamespace("user") {
+		return fmt.Errorf("uid_map and gid_map require a user namespace")
+	}
+	if _, err := parseIDMap(o.UIDMap); err != nil {
+		return fmt.Errorf("invalid uid_map: %v", err)
+	}
+	if _, err := parseIDMap(o.GIDMap); err != nil {
+		return fmt.Errorf("invalid gid_map: %v", err)
+	}
+	if o.Type == TypeForking && o.hasNamespace("pid") {
+		// The parent is init of the namespace, its exit kills the daemon
+		return fmt.Errorf("forking services can't use a pid namespace")
+	}
+	return nil
+}
+
+// parseIDMap parses "<inside> <outside> <count>" entries like the lines of
+// /proc/<pid>/uid_map
+func parseIDMap(entries []string) ([]syscall.SysProcIDMap, error) {
+	mappings := []syscall.SysProcIDMap{}
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%q is not \"<inside> <outside> <count>\"", entry)
+		}
+		values := [3]int{}
+		for i, field := range fields {
+			value, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not \"<inside> <outside> <count>\"", entry)
+			}
+			values[i] = int(value)
+		}
+		if values[2] == 0 {
+			return nil, fmt.Errorf("%q maps no IDs", entry)
+		}
+		mappings = append(mappings, syscall.SysProcIDMap{ContainerID: values[0], HostID: values[1], Size: values[2]})
+	}
+	return mappings, nil
+}
+
+// namespaceAttr adds the namespaces of the main process to attr. Without
+// uid_map and gid_map the user namespace maps root to the daemon user, which
+// keeps the privileges of the exec helper, and the user of the service to
+// itself.
+func (p *Process) namespaceAttr(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if len(p.namespaces) == 0 {
+		return attr
+	}
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	for _, name := range p.namespaces {
+		attr.Cloneflags |= namespaceKinds[name].flag
+	}
+	if attr.Cloneflags&syscall.CLONE_NEWUSER == 0 {
+		return attr
+	}
+
+	attr.UidMappings = p.uidMap
+	if len(attr.UidMappings) == 0 {
+		attr.UidMappings = defaultIDMap(os.Getuid(), attr.Credential, func(c *syscall.Credential) uint32 { return c.Uid })
+	}
+	attr.GidMappings = p.gidMap
+	if len(attr.GidMappings) == 0 {
+		attr.GidMappings = defaultIDMap(os.Getgid(), attr.Credential, func(c *syscall.Credential) uint32 { return c.Gid })
+	}
+	if attr.Credential != nil {
+		// setgroups is denied in a user namespace without a privileged
+		// gid_map writer
+		attr.Credential.NoSetGroups = true
+	}
+	return attr
+}
+
+// defaultIDMap maps root to the daemon ID and the ID of the service user, if
+// there is one, to itself
+func defaultIDMap(daemonID int, credential *syscall.Credential, id func(*syscall.Credential) uint32) []syscall.SysProcIDMap {
+	mappings := []syscall.SysProcIDMap{{ContainerID: 0, HostID: daemonID, Size: 1}}
+	if credential != nil && id(credential) != 0 {
+		mappings = append(mappings, syscall.SysProcIDMap{ContainerID: int(id(credential)), HostID: int(id(credential)), Size: 1})
+	}
+	return mappings
+}
+
+// Namespaces returns the namespaces of the main process by kind, like
+// "net:[4026532290]", with empty values while it doesn't run. It's nil without
+// namespaces.
+func (s *Service) Namespaces() map[string]string {
+	names := s.Options.namespaceList()
+	if len(names) == 0 {
+		return nil
+	}
+	namespaces := make(map[string]string)
+	running := s.Process.Status() == "running"
+	for _, name := range names {
+		namespaces[name] = ""
+		if running {
+			namespaces[name], _ = procfs.Namespace(s.GetPID(), namespaceKinds[name].proc)
+		}
+	}
+	if s.Options.Hostname != "" {
+		namespaces["hostname"] = s.Options.Hostname
+	}
+	return namespaces
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestParseIDMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []syscall.SysProcIDMap
+		wantErr string
+	}{
+		{
+			name: "none",
+			want: []syscall.SysProcIDMap{},
+		},
+		{
+			name:    "single range",
+			entries: []string{"0 100000 65536"},
+			want:    []syscall.SysProcIDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		},
+		{
+			name:    "several ranges with extra whitespace",
+			entries: []string{" 0  1000 1 ", "1\t100000\t999"},
+			want: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: 1000, Size: 1},
+				{ContainerID: 1, HostID: 100000, Size: 999},
+			},
+		},
+		{
+			name:    "largest ID",
+			entries: []string{"4294967295 4294967295 1"},
+			want:    []syscall.SysProcIDMap{{ContainerID: 4294967295, HostID: 4294967295, Size: 1}},
+		},
+		{
+			name:    "missing field",
+			entries: []string{"0 1000"},
+			wantErr: `"0 1000" is not "<inside> <outside> <count>"`,
+		},
+		{
+			name:    "extra field",
+			entries: []string{"0 1000 1 1"},
+			wantErr: `"0 1000 1 1" is not "<inside> <outside> <count>"`,
+		},
+		{
+			name:    "negative ID",
+			entries: []string{"0 -1 1"},
+			wantErr: `"0 -1 1" is not "<inside> <outside> <count>"`,
+		},
+		{
+			name:    "ID out of range",
+			entries: []string{"0 4294967296 1"},
+			wantErr: `"0 4294967296 1" is not "<inside> <outside> <count>"`,
+		},
+		{
+			name:    "not a number",
+			entries: []string{"root 1000 1"},
+			wantErr: `"root 1000 1" is not "<inside> <outside> <count>"`,
+		},
+		{
+			name:    "empty range",
+			entries: []string{"0 1000 1", "1 2000 0"},
+			wantErr: `"1 2000 0" maps no IDs`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseIDMap(test.entries)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("parseIDMap() error = %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseIDMap() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseIDMap() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDefaultIDMap(t *testing.T) {
+	uid := func(credential *syscall.Credential) uint32 { return credential.Uid }
+	tests := []struct {
+		name       string
+		credential *syscall.Credential
+		want       []syscall.SysProcIDMap
+	}{
+		{
+			name: "daemon user",
+			want: []syscall.SysProcIDMap{{ContainerID: 0, HostID: 1000, Size: 1}},
+		},
+		{
+			name:       "service user",
+			credential: &syscall.Credential{Uid: 33},
+			want:       []syscall.SysProcIDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 33, HostID: 33, Size: 1}},
+		},
+		{
+			name:       "service runs as root",
+			credential: &syscall.Credential{Uid: 0},
+			want:       []syscall.SysProcIDMap{{ContainerID: 0, HostID: 1000, Size: 1}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := defaultIDMap(1000, test.credential, uid); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("defaultIDMap() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"ops-ctrl/pkg/procfs"
+	"ops-ctrl/pkg/securedir"
+)
+
+// NotifyDir holds the notification sockets of the services. Services of
+// other users can enter it but not list it, a socket belongs to the user of
+// its service.
+const NotifyDir = securedir.RuntimeDir + "/notify"
+
+// notifySocket receives sd_notify style messages ("READY=1\nSTATUS=...")
+// from a single service. Messages of processes outside the service are
+// dropped, the sender is known from its SCM_CREDENTIALS.
+type notifySocket struct {
+	id        string
+	path      string
+	conn      *net.UnixConn
+	process   *Process // Senders must be its processes
+	ready     chan struct{}
+	readyOnce sync.Once
+	watchdog  chan struct{} // Receives WATCHDOG=1 pings
+	trigger   chan struct{} // Receives WATCHDOG=trigger
+	mu        sync.Mutex
+	mainPID   int
+	status    string
+}
+
+func newNotifySocket(id string, process *Process) (*notifySocket, error) {
+	if err := securedir.Create(securedir.RuntimeDir, 0755); err != nil {
+		return nil, fmt.Errorf("invalid runtime directory: %v", err)
+	}
+	if err := securedir.Create(NotifyDir, 0711); err != nil {
+		return nil, fmt.Errorf("invalid notify directory: %v", err)
+	}
+	path := filepath.Join(NotifyDir, id+".sock")
+	os.Remove(path)
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on notify socket: %v", err)
+	}
+	if err := setupNotifySocket(conn, path, process.credential); err != nil {
+		conn.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	n := &notifySocket{
+		id:       id,
+		path:     path,
+		conn:     conn,
+		process:  process,
+		ready:    make(chan struct{}),
+		watchdog: make(chan struct{}, 1),
+		trigger:  make(chan struct{}, 1),
+	}
+	go n.listen()
+	return n, nil
+}
+
+// setupNotifySocket asks for the credentials of the senders and lets only the
+// user of the service, or the daemon's user without one, send to the socket
+func setupNotifySocket(conn *net.UnixConn, path string, owner *credential) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("failed to set up notify socket: %v", err)
+	}
+	var optionErr error
+	if err := raw.Control(func(fd uintptr) {
+		optionErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	}); err != nil || optionErr != nil {
+		return fmt.Errorf("failed to receive the credentials of notify messages: %v", errors.Join(err, optionErr))
+	}
+	if owner != nil {
+		if err := os.Chown(path, int(owner.uid), int(owner.gid)); err != nil {
+			return fmt.Errorf("failed to hand the notify socket to the service user: %v", err)
+		}
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		return fmt.Errorf("failed to set up notify socket: %v", err)
+	}
+	return nil
+}
+
+func (n *notifySocket) listen() {
+	buffer := make([]byte, 4096)
+	// Only room for the credentials, file descriptors sent along are closed
+	// by the kernel
+	control := make([]byte, syscall.CmsgSpace(syscall.SizeofUcred))
+	for {
+		count, controlCount, _, _, err := n.conn.ReadMsgUnix(buffer, control)
+		if err != nil {
+			return
+		}
+		sender := senderPID(control[:controlCount])
+		if sender == 0 || !n.process.owns(sender) {
+			fmt.Printf("Ignoring notification for service %s from PID %d outside the service\n", n.id, sender)
+			continue
+		}
+		n.handle(string(buffer[:count]), sender)
+	}
+}
+
+// senderPID returns the PID of the SCM_CREDENTIALS message, 0 without one
+func senderPID(control []byte) int {
+	messages, err := syscall.ParseSocketControlMessage(control)
+	if err != nil {
+		return 0
+	}
+	for _, message := range messages {
+		if credentials, err := syscall.ParseUnixCredentials(&message); err == nil {
+			return int(credentials.Pid)
+		}
+	}
+	return 0
+}
+
+// handle applies a message of sender, a process of the service
+func (n *notifySocket) handle(message string, sender int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, line := range strings.Split(message, "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "READY":
+			if value == "1" {
+				n.readyOnce.Do(func() { close(n.ready) })
+			}
+		case "MAINPID":
+			pid, err := strconv.Atoi(value)
+			if err != nil || pid <= 0 {
+				continue
+			}
+			// The sender reports the PID in its own PID namespace
+			hostPID, err := procfs.HostPID(pid, sender)
+			if err != nil || !n.process.owns(hostPID) {
+				fmt.Printf("Ignoring MAINPID=%d of service %s, it is not a process of the service\n", pid, n.id)
+				continue
+			}
+			n.mainPID = hostPID
+		case "STATUS":
+			n.status = value
+		case "WATCHDOG":
+			switch value {
+			case "1":
+				wake(n.watchdog)
+			case "trigger":
+				wake(n.trigger)
+			}
+		}
+	}
+}
+
+// wake sends to a buffered channel without blocking, pings that arrive
+// before the last one was seen are merged
+func wake(channel chan struct{}) {
+	select {
+	case channel <- struct{}{}:
+	default:
+	}
+}
+
+// MainPID returns the PID reported with MAINPID= in the PID namespace of the
+// daemon, 0 if none was sent
+func (n *notifySocket) MainPID() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.mainPID
+}
+
+// StatusText returns the last STATUS= message
+func (n *notifySocket) StatusText() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.status
+}
+
+func (n *notifySocket) Close() {
+	n.conn.Close()
+	os.Remove(n.path)
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// ServiceType defines when the manager considers a service started
+type ServiceType string
+
+const (
+	TypeSimple  ServiceType = "simple"  // Started as soon as the process is spawned, exec helper errors show as exit code 127
+	TypeExec    ServiceType = "exec"    // Started once the binary was executed, exec errors fail the start
+	TypeForking ServiceType = "forking" // Started when the parent exits, the main PID is read from the PID file
+	TypeNotify  ServiceType = "notify"  // Started when the process sends READY=1 to $NOTIFY_SOCKET
+)
+
+// RestartPolicy decides if the supervisor restarts a service that exited
+type RestartPolicy string
+
+const (
+	RestartNo        RestartPolicy = "no"         // Never restart
+	RestartOnFailure RestartPolicy = "on-failure" // Restart after a non-zero exit code or an unclean signal
+	RestartAlways    RestartPolicy = "always"     // Restart whenever the service exits on its own
+)
+
+const (
+	defaultStartTimeout = 90 * time.Second
+	defaultStopTimeout  = 10 * time.Second
+	defaultRestartSec   = time.Second
+	defaultHookTimeout  = 90 * time.Second
+	defaultWatchdogSig  = "SIGABRT"
+)
+
+func (r RestartPolicy) IsValid() bool {
+	switch r {
+	case RestartNo, RestartOnFailure, RestartAlways:
+		return true
+	}
+	return false
+}
+
+func (t ServiceType) IsValid() bool {
+	switch t {
+	case TypeSimple, TypeExec, TypeForking, TypeNotify:
+		return true
+	}
+	return false
+}
+
+// Options describes how a service is run, either from a request or from a
+// [services.<name>] table in config.toml
+type Options struct {
+	Binary                string        `toml:"binary"`                  // Program binary path
+	Args                  []string      `toml:"args"`                    // Arguments for the program binary
+	Env                   []string      `toml:"env"`                     // Environment variables, KEY=value, may use ${VAR}
+	EnvFile               []string      `toml:"env_file"`                // dotenv files, a "-" prefix ignores missing files
+	PassEnv               []string      `toml:"pass_environment"`        // Daemon environment variables passed to the service
+	WorkingDir            string        `toml:"working_dir"`             // Working directory for the program
+	Type                  ServiceType   `toml:"type"`                    // simple, exec, forking or notify
+	PIDFile               string        `toml:"pid_file"`                // PID file written by forking services
+	StartTimeout          time.Duration `toml:"start_timeout"`           // How long forking and notify services may take to start
+	LogPath               string        `toml:"log_path"`                // File the output is appended to
+	User                  string        `toml:"user"`                    // User name or UID the service runs as
+	Group                 string        `toml:"group"`                   // Group name or GID, defaults to the primary group of User
+	StopTimeout           time.Duration `toml:"stop_timeout"`            // How long to wait after SIGTERM before sending SIGKILL
+	Restart               RestartPolicy `toml:"restart"`                 // no, on-failure or always
+	RestartSec            time.Duration `toml:"restart_sec"`             // Delay before an automatic restart
+	ExecStartPre          []string      `toml:"exec_start_pre"`          // Commands before the start, a failure aborts it
+	ExecStartPost         []string      `toml:"exec_start_post"`         // Commands once started, a failure stops the service
+	ExecStop              []string      `toml:"exec_stop"`               // Commands asked to stop the service before SIGTERM
+	ExecStopPost          []string      `toml:"exec_stop_post"`          // Commands after the service stopped or exited
+	ExecReload            []string      `toml:"exec_reload"`             // Commands run by "reload"
+	HookTimeout           time.Duration `toml:"hook_timeout"`            // How long each hook command may run
+	WatchdogSec           time.Duration `toml:"watchdog_sec"`            // Longest time between two watchdog pings, 0 disables the watchdog
+	WatchdogSignal        string        `toml:"watchdog_signal"`         // Sent when a ping is missed, SIGABRT by default
+	WatchdogFile          string        `toml:"watchdog_file"`           // File the service touches as a ping, besides WATCHDOG=1
+	Namespaces            []string      `toml:"namespaces"`              // New namespaces of the main process: pid, mount, uts, ipc, net (loopback only) and user
+	Hostname              string        `toml:"hostname"`                // Host name in the uts namespace
+	UIDMap                []string      `toml:"uid_map"`                 // "<inside> <outside> <count>" entries of the user namespace, the service user by default
+	GIDMap                []string      `toml:"gid_map"`                 // Same for groups
+	PrivateTmp            bool          `toml:"private_tmp"`             // Empty /tmp and /var/tmp for the main process
+	ReadOnlyPaths         []string      `toml:"read_only_paths"`         // Mounted read-only with everything below
+	InaccessiblePaths     []string      `toml:"inaccessible_paths"`      // Hidden behind an empty file or directory
+	BindPaths             []string      `toml:"bind_paths"`              // "source[:target][:ro|rw]" bind mounts
+	RootDirectory         string        `toml:"root_directory"`          // The main process is chrooted here, other paths are inside it
+	ProtectSystem         string        `toml:"protect_system"`          // no, yes (/usr and /boot read-only), full (and /etc) or strict (everything but /dev, /proc and /sys)
+	CapabilityBoundingSet []string      `toml:"capability_bounding_set"` // Capabilities kept in the bounding set, or dropped with a "~" prefix
+	AmbientCapabilities   []string      `toml:"ambient_capabilities"`    // Capabilities passed to a service running as another user
+	NoNewPrivileges       bool          `toml:"no_new_privileges"`       // setuid binaries and file capabilities grant nothing
+	SystemCallAllow       []string      `toml:"system_call_allow"`       // Only these system calls and "@groups" are allowed
+	SystemCallDeny        []string      `toml:"system_call_deny"`        // System calls and "@groups" that are never allowed
+	SystemCallAction      string        `toml:"system_call_action"`      // kill (default), errno or log for filtered system calls
+	LandlockReadPaths     []string      `toml:"landlock_read_paths"`     // With any landlock path, only these can be read
+	LandlockWritePaths    []string      `toml:"landlock_write_paths"`    // Read, written, created and removed
+	LandlockExecutePaths  []string      `toml:"landlock_execute_paths"`  // Read and executed, the binary always is
+	LandlockMode          string        `toml:"landlock_mode"`           // best-effort (default) runs unrestricted where the kernel lacks Landlock, required fails
+	Nice                  *int          `toml:"nice"`                    // -20 (highest) to 19
+	CPUAffinity           string        `toml:"cpu_affinity"`            // CPUs the service may run on, like "0-3,6"
+	CPUSchedulingPolicy   string        `toml:"cpu_scheduling_policy"`   // other, batch, idle, fifo or rr
+	CPUSchedulingPriority int           `toml:"cpu_scheduling_priority"` // 1-99 for fifo and rr
+	IOSchedulingClass     string        `toml:"io_scheduling_class"`     // realtime, best-effort, idle or none
+	IOSchedulingPriority  *int          `toml:"io_scheduling_priority"`  // 0 (highest) to 7, 4 by default
+	OOMScoreAdjust        *int          `toml:"oom_score_adjust"`        // -1000 (never killed) to 1000 (killed first)
+	Umask                 string        `toml:"umask"`                   // Octal file mode creation mask, like "0027"
+	TTYPath               string        `toml:"tty_path"`                // Terminal used as stdin, stdout, stderr and controlling terminal, like /dev/tty1, excludes log_path
+	TTYReset              bool          `toml:"tty_reset"`               // Reset the terminal settings and screen before every start
+	TTYVHangup            bool          `toml:"tty_vhangup"`             // Hang up processes of earlier sessions before every start
+	RequiresMountsFor     []string      `toml:"requires_mounts_for"`     // Paths whose mounts are mounted before every start
+	Alias                 string        `toml:"-"`                       // Alias or definition the service was started from
+}
+
+// withDefaults fills in the values that were left empty
+func (o Options) withDefaults() Options {
+	if o.Type == "" {
+		o.Type = TypeSimple
+	}
+	if o.WorkingDir == "" {
+		o.WorkingDir = "/"
+	}
+	if o.StartTimeout <= 0 {
+		o.StartTimeout = defaultStartTimeout
+	}
+	if o.StopTimeout <= 0 {
+		o.StopTimeout = defaultStopTimeout
+	}
+	if o.Restart == "" {
+		o.Restart = RestartNo
+	}
+	if o.RestartSec <= 0 {
+		o.RestartSec = defaultRestartSec
+	}
+	if o.HookTimeout <= 0 {
+		o.HookTimeout = defaultHookTimeout
+	}
+	if o.WatchdogSignal == "" {
+		o.WatchdogSignal = defaultWatchdogSig
+	}
+	return o
+}
+
+func (o Options) validate() error {
+	if o.Binary == "" {
+		return fmt.Errorf("program binary undefined")
+	}
+	if !o.Type.IsValid() {
+		return fmt.Errorf("invalid service type: %s", o.Type)
+	}
+	if !o.Restart.IsValid() {
+		return fmt.Errorf("invalid restart policy: %s", o.Restart)
+	}
+	if o.Type == TypeForking && o.PIDFile == "" {
+		return fmt.Errorf("forking services require a pid_file")
+	}
+	if _, err := GetSignal(o.WatchdogSignal); err != nil {
+		return fmt.Errorf("invalid watchdog_signal: %s", o.WatchdogSignal)
+	}
+	if o.WatchdogFile != "" && o.WatchdogSec <= 0 {
+		return fmt.Errorf("watchdog_file requires watchdog_sec")
+	}
+	if o.WatchdogFile != "" && !filepath.IsAbs(o.WatchdogFile) {
+		return fmt.Errorf("watchdog_file must be an absolute path: %s", o.WatchdogFile)
+	}
+	for _, path := range o.RequiresMountsFor {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("requires_mounts_for must list absolute paths: %s", path)
+		}
+	}
+	return nil
+}
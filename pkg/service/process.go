@@ -2,77 +2,535 @@ package service
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"sync"
 	"syscall"
+	"time"
+
+	"ops-ctrl/pkg/procfs"
+	"ops-ctrl/pkg/sandbox"
 )
 
 // Process encapsulates the execution logic
 type Process struct {
-	command      string
-	args         []string
-	env          []string
-	workingDir   string
-	cmd          *exec.Cmd
-	outputBuffer *bytes.Buffer
-	mu           sync.Mutex
+	command       string
+	args          []string
+	env           []string
+	envFiles      []string
+	passEnv       []string
+	environment   []string // Effective environment of the last start, env_file values masked
+	workingDir    string
+	logPath       string
+	credential    *credential // User and group to run as, nil for the daemon user
+	cmd           *exec.Cmd
+	outputBuffer  *bytes.Buffer
+	output        *os.File      // Read end of the output pipe, nil once every writer closed it
+	outputLog     *os.File      // log_path the output is copied to, nil without it
+	copying       chan struct{} // Closed when copyOutput returns
+	mainPID       int           // PID that is supervised, differs from cmd for forking services
+	exited        chan struct{} // Closed when the main PID exits
+	exitCode      int
+	exitSignal    syscall.Signal // Signal that killed the main PID, 0 if it exited
+	startedAt     time.Time
+	watchdog      bool     // Started through the exec helper to export $WATCHDOG_PID
+	waitExec      bool     // Start waits until the exec helper executed the binary
+	namespaces    []string // New namespaces of the main process
+	filesystem    *sandbox.Filesystem
+	boundingDrops []uintptr // Capabilities dropped from the bounding set
+	ambientCaps   []uintptr
+	noNewPrivs    bool
+	syscallFilter *sandbox.SyscallFilter
+	landlock      *sandbox.Landlock
+	scheduling    *sandbox.Scheduling
+	tty           *terminal // Terminal used for input and output instead of the output pipe
+	hostname      string    // Host name in the uts namespace
+	uidMap        []syscall.SysProcIDMap
+	gidMap        []syscall.SysProcIDMap
+	mu            sync.Mutex
 }
 
 // NewProcess initializes a new process
-func NewProcess(command string, args []string, env []string, dir string) *Process {
-	return &Process{
-		command:    command,
-		args:       args,
-		env:        env,
-		workingDir: dir,
+func NewProcess(opts Options) *Process {
+	p := &Process{
+		command:    opts.Binary,
+		args:       opts.Args,
+		env:        opts.Env,
+		envFiles:   opts.EnvFile,
+		passEnv:    opts.PassEnv,
+		workingDir: opts.WorkingDir,
+		logPath:    opts.LogPath,
+		watchdog:   opts.WatchdogSec > 0,
+		waitExec:   opts.Type == TypeExec,
+		namespaces: opts.namespaceList(),
+		hostname:   opts.Hostname,
+		tty:        opts.terminal(),
 	}
+	// Validated by Options.resolve
+	p.uidMap, _ = parseIDMap(opts.UIDMap)
+	p.gidMap, _ = parseIDMap(opts.GIDMap)
+	p.filesystem, _ = opts.filesystem()
+	p.boundingDrops, _ = opts.boundingDrops()
+	p.ambientCaps, _ = opts.ambientCapabilities()
+	p.noNewPrivs = opts.NoNewPrivileges
+	p.syscallFilter, _ = opts.syscallFilter()
+	p.landlock, _ = opts.landlock()
+	p.scheduling, _ = opts.scheduling()
+	return p
 }
 
-// Start initiates the process
-func (p *Process) Start() error {
+// Start initiates the process, extraEnv is appended to the configured environment
+func (p *Process) Start(extraEnv ...string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Initialize the command
-	p.cmd = exec.Command(p.command, p.args...)
+	resolved, err := p.buildEnvironment(extraEnv)
+	if err != nil {
+		return err
+	}
+	environment := resolved.list()
+	p.environment = resolved.masked()
+
+	// Initialize the command, the exec helper executes the binary in the
+	// forked process once it has done what can't be done here
+	args := expandArguments(p.args, environment)
+	attr := p.namespaceAttr(p.sysProcAttr())
+	spec := p.helperSpec()
+	var status, statusWriter *os.File // Status pipe of the exec helper
+	if spec != nil {
+		if p.waitExec {
+			if status, statusWriter, err = os.Pipe(); err != nil {
+				return fmt.Errorf("failed to create status pipe: %v", err)
+			}
+			defer status.Close()
+			defer statusWriter.Close()
+			spec.StatusFD = helperStatusFD
+		}
+		if attr != nil {
+			spec.Credential, spec.AmbientCaps = attr.Credential, attr.AmbientCaps
+			attr.Credential, attr.AmbientCaps = nil, nil
+		}
+		encoded, err := json.Marshal(spec)
+		if err != nil {
+			return fmt.Errorf("failed to encode helper spec: %v", err)
+		}
+		environment = append(environment[:len(environment):len(environment)], helperVariable+"="+string(encoded))
+		p.cmd = exec.Command(helperBinary, append([]string{p.command}, args...)...)
+	} else {
+		p.cmd = exec.Command(p.command, args...)
+	}
 	p.cmd.Dir = p.workingDir
-	p.cmd.Env = append(p.cmd.Env, p.env...)
+	if p.filesystem != nil && p.filesystem.Root != "" {
+		// The exec helper changes the directory once it's in the root
+		p.cmd.Dir = "/"
+	}
+	p.cmd.Env = environment
+	p.cmd.SysProcAttr = attr
+	if statusWriter != nil {
+		p.cmd.ExtraFiles = []*os.File{statusWriter}
+	}
 
-	p.outputBuffer = &bytes.Buffer{}
-	p.cmd.Stdout = p.outputBuffer
-	p.cmd.Stderr = p.outputBuffer
+	// Output is copied through our own pipe, exec.Cmd would otherwise wait
+	// until every process holding the pipe, including daemonized children,
+	// exits. Services on a terminal use it for input and output instead.
+	var reader, writer, logFile *os.File
+	if p.tty != nil {
+		if writer, err = p.openTerminal(); err != nil {
+			return err
+		}
+		p.cmd.Stdin = writer
+		p.cmd.SysProcAttr = terminalAttr(attr)
+	} else {
+		if reader, writer, err = os.Pipe(); err != nil {
+			return fmt.Errorf("failed to create output pipe: %v", err)
+		}
+		if p.logPath != "" {
+			logFile, err = os.OpenFile(p.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				reader.Close()
+				writer.Close()
+				return fmt.Errorf("failed to open log file: %v", err)
+			}
+		}
+	}
+	if p.outputBuffer == nil {
+		p.outputBuffer = &bytes.Buffer{}
+	}
+	p.cmd.Stdout = writer
+	p.cmd.Stderr = writer
 
-	err := p.cmd.Start()
+	err = startChild(p.cmd)
+	writer.Close()
+	if statusWriter != nil {
+		statusWriter.Close()
+	}
 	if err != nil {
+		if reader != nil {
+			reader.Close()
+		}
+		if logFile != nil {
+			logFile.Close()
+		}
+		if errors.Is(err, syscall.EPERM) && len(p.namespaces) > 0 {
+			return fmt.Errorf("failed to start process: creating namespaces %v is not permitted: %v", p.namespaces, err)
+		}
 		return fmt.Errorf("failed to start process: %v", err)
 	}
+	if reader != nil {
+		p.copyFrom(reader, logFile)
+	}
+
+	p.mainPID = p.cmd.Process.Pid
+	p.startedAt = time.Now()
+	p.exited = make(chan struct{})
+	go p.waitCommand(p.cmd, p.exited)
 
+	if status != nil {
+		return execError(status)
+	}
 	return nil
 }
 
-// Stop terminates the process
-func (p *Process) SignalProcess(signal os.Signal) error {
+// execError waits until the exec helper executed the binary and returns why
+// it failed otherwise. The status pipe is closed on exec, the helper writes
+// its error to it before exiting.
+func execError(status *os.File) error {
+	message, err := io.ReadAll(status)
+	if err != nil {
+		return fmt.Errorf("failed to read exec status: %v", err)
+	}
+	if len(message) > 0 {
+		return errors.New(string(message))
+	}
+	return nil
+}
+
+// sysProcAttr returns the attributes of the main process and the hooks
+func (p *Process) sysProcAttr() *syscall.SysProcAttr {
+	if p.credential == nil && len(p.ambientCaps) == 0 {
+		return nil
+	}
+	attr := &syscall.SysProcAttr{AmbientCaps: p.ambientCaps}
+	if p.credential != nil {
+		attr.Credential = &syscall.Credential{
+			Uid:    p.credential.uid,
+			Gid:    p.credential.gid,
+			Groups: p.credential.groups,
+		}
+	}
+	return attr
+}
+
+// resetOutput discards the captured output before a new start
+func (p *Process) resetOutput() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.outputBuffer = &bytes.Buffer{}
+}
+
+// copyFrom starts copying the output read from reader, p.mu must be held
+func (p *Process) copyFrom(reader *os.File, logFile *os.File) {
+	p.output = reader
+	p.outputLog = logFile
+	p.copying = make(chan struct{})
+	go p.copyOutput(reader, p.outputBuffer, logFile, p.copying)
+}
+
+// copyOutput collects the output until every writer has closed the pipe,
+// logFile is optional. A read deadline pauses it without closing the pipe.
+func (p *Process) copyOutput(reader *os.File, buffer *bytes.Buffer, logFile *os.File, copying chan struct{}) {
+	defer close(copying)
+	chunk := make([]byte, 4096)
+	for {
+		count, err := reader.Read(chunk)
+		if count > 0 {
+			p.mu.Lock()
+			buffer.Write(chunk[:count])
+			p.mu.Unlock()
+			if logFile != nil {
+				logFile.Write(chunk[:count])
+			}
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	p.mu.Lock()
+	if p.output == reader {
+		p.output = nil
+		p.outputLog = nil
+	}
+	p.mu.Unlock()
+	reader.Close()
+	if logFile != nil {
+		logFile.Close()
+	}
+}
+
+// PauseOutput stops copying the output, so a re-executed daemon continues
+// reading the pipe where this one stopped. It returns the read end, nil if
+// the output is not captured or every writer closed the pipe.
+func (p *Process) PauseOutput() (*os.File, error) {
+	p.mu.Lock()
+	output, copying := p.output, p.copying
+	p.mu.Unlock()
+	if output == nil {
+		return nil, nil
+	}
+	if err := output.SetReadDeadline(time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to pause output: %v", err)
+	}
+	<-copying
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.output != output {
+		return nil, nil
+	}
+	return output, nil
+}
+
+// ResumeOutput copies the output again after PauseOutput
+func (p *Process) ResumeOutput() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.output == nil {
+		return
+	}
+	select {
+	case <-p.copying:
+	default:
+		// Not paused
+		return
+	}
+	p.output.SetReadDeadline(time.Time{})
+	p.copyFrom(p.output, p.outputLog)
+}
+
+// waitCommand reaps the spawned command and records its exit code
+func (p *Process) waitCommand(cmd *exec.Cmd, exited chan struct{}) {
+	err := cmd.Wait()
+	releaseChild(cmd.Process.Pid)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exitCode = exitCode(cmd.ProcessState, err)
+	p.exitSignal = 0
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		p.exitSignal = status.Signal()
+	}
+	close(exited)
+}
+
+// TrackPID makes pid the supervised main PID. The daemon is a subreaper, so
+// a daemonized grandchild is reparented to it and can be waited for directly.
+func (p *Process) TrackPID(pid int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.mainPID = pid
+	p.exited = make(chan struct{})
+	claimChild(pid)
+	go p.waitPID(pid, p.exited)
+}
+
+// buildEnvironment resolves the environment of the service, p.mu must be held
+func (p *Process) buildEnvironment(extraEnv []string) (*environment, error) {
+	home := ""
+	if p.credential != nil {
+		home = p.credential.home
+	}
+	return buildEnvironment(home, p.passEnv, p.envFiles, p.env, extraEnv)
+}
 
-	if p.cmd == nil || p.cmd.Process == nil {
+// Adopt supervises pid, a process that was started by an earlier instance of
+// the daemon. output is the read end of its output pipe if it was handed
+// over, otherwise the output is no longer captured. The environment isn't
+// part of the saved state, it's resolved again from the definition with
+// extraEnv.
+func (p *Process) Adopt(pid int, startedAt time.Time, output *os.File, extraEnv ...string) error {
+	p.mu.Lock()
+	p.startedAt = startedAt
+	if resolved, err := p.buildEnvironment(extraEnv); err == nil {
+		p.environment = resolved.masked()
+	}
+	if output != nil {
+		var logFile *os.File
+		if p.logPath != "" {
+			var err error
+			logFile, err = os.OpenFile(p.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				p.mu.Unlock()
+				output.Close()
+				return fmt.Errorf("failed to open log file: %v", err)
+			}
+		}
+		p.outputBuffer = &bytes.Buffer{}
+		p.copyFrom(output, logFile)
+	}
+	p.mu.Unlock()
+	p.TrackPID(pid)
+	return nil
+}
+
+// OutputPipe returns the read end of the output pipe, nil if the output is
+// not captured
+func (p *Process) OutputPipe() *os.File {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.output
+}
+
+// waitPID waits for a process that was not started through exec.Cmd
+func (p *Process) waitPID(pid int, exited chan struct{}) {
+	var status syscall.WaitStatus
+	code := -1
+	var signal syscall.Signal
+	for {
+		_, err := syscall.Wait4(pid, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == nil {
+			code = status.ExitStatus()
+			if status.Signaled() {
+				signal = status.Signal()
+			}
+			break
+		}
+		// Not our child, fall back to polling
+		if !pidAlive(pid) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	releaseChild(pid)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exitCode = code
+	p.exitSignal = signal
+	close(exited)
+}
+
+func pidAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func exitCode(state *os.ProcessState, err error) int {
+	if state != nil {
+		return state.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+// Exited returns a channel that is closed when the main PID exits
+func (p *Process) Exited() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exited
+}
+
+// ExitCode returns the exit code of the main PID, -1 if it was killed by a signal
+func (p *Process) ExitCode() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exitCode
+}
+
+// ExitSignal returns the signal that killed the main PID, 0 if it exited normally
+func (p *Process) ExitSignal() syscall.Signal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exitSignal
+}
+
+// StartedAt returns when the process was last started
+func (p *Process) StartedAt() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.startedAt
+}
+
+// PID returns the supervised main PID, 0 if the process was never started
+func (p *Process) PID() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mainPID
+}
+
+// owns reports if pid is the main PID, the spawned command or one of their
+// descendants
+func (p *Process) owns(pid int) bool {
+	p.mu.Lock()
+	roots := []int{p.mainPID}
+	if p.cmd != nil && p.cmd.Process != nil {
+		roots = append(roots, p.cmd.Process.Pid)
+	}
+	p.mu.Unlock()
+
+	for _, root := range roots {
+		if root == 0 {
+			continue
+		}
+		if pid == root {
+			return true
+		}
+		for _, descendant := range procfs.Descendants(root) {
+			if pid == descendant {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Signal sends signal to the main PID without waiting for it to exit
+func (p *Process) Signal(signal os.Signal) error {
+	p.mu.Lock()
+	pid := p.mainPID
+	exited := p.exited
+	p.mu.Unlock()
+
+	if pid == 0 || isClosed(exited) {
 		return fmt.Errorf("process not running")
 	}
 
-	err := p.cmd.Process.Signal(signal)
+	sig, ok := signal.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal: %v", signal)
+	}
+	if err := syscall.Kill(pid, sig); err != nil {
+		return fmt.Errorf("failed to signal process: %v", err)
+	}
+	return nil
+}
+
+// SignalProcess sends signal to the main PID and waits for SIGTERM and
+// SIGKILL to terminate it
+func (p *Process) SignalProcess(signal os.Signal) error {
+	exited := p.Exited()
+	err := p.Signal(signal)
 	if err != nil {
-		return fmt.Errorf("failed to stop process: %v", err)
+		return err
 	}
 
 	switch signal {
 	case syscall.SIGTERM, syscall.SIGKILL:
 		// Wait for process to exit
-		err = p.cmd.Wait()
-		if err != nil {
-			return fmt.Errorf("failed to wait for process termination: %v", err)
-		}
+		<-exited
 	default:
 		// Handle other signals if needed
 		fmt.Printf("Process received signal: %v\n", signal)
@@ -81,22 +539,45 @@ func (p *Process) SignalProcess(signal os.Signal) error {
 	return nil
 }
 
+func isClosed(channel <-chan struct{}) bool {
+	if channel == nil {
+		return true
+	}
+	select {
+	case <-channel:
+		return true
+	default:
+		return false
+	}
+}
+
 // Status returns the current status of the process
 func (p *Process) Status() string {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if p.cmd != nil && p.cmd.ProcessState != nil && p.cmd.ProcessState.Exited() {
+	if p.PID() == 0 {
+		return "initialized"
+	}
+	if isClosed(p.Exited()) {
 		return "exited"
 	}
 
 	return "running"
 }
 
+// Environment returns the effective environment of the last start, values
+// that came from an env_file are masked
+func (p *Process) Environment() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.environment
+}
+
 // Output returns the output buffer contents
 func (p *Process) Output() string {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.outputBuffer == nil {
+		return ""
+	}
 	return p.outputBuffer.String()
 }
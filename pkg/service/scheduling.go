@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+
+	"ops-ctrl/pkg/procfs"
+	"ops-ctrl/pkg/sandbox"
+)
+
+// defaultIOPriority is the priority of realtime and best-effort I/O without
+// io_scheduling_priority
+const defaultIOPriority = 4
+
+// scheduling returns the scheduling settings of the main process, nil when
+// it inherits the ones of the daemon
+func (o Options) scheduling() (*sandbox.Scheduling, error) {
+	if o.Nice == nil && o.CPUAffinity == "" && o.CPUSchedulingPolicy == "" && o.CPUSchedulingPriority == 0 &&
+		o.IOSchedulingClass == "" && o.IOSchedulingPriority == nil && o.OOMScoreAdjust == nil && o.Umask == "" {
+		return nil, nil
+	}
+
+	scheduling := &sandbox.Scheduling{
+		Nice:           o.Nice,
+		CPUPolicy:      o.CPUSchedulingPolicy,
+		CPUPriority:    o.CPUSchedulingPriority,
+		IOClass:        o.IOSchedulingClass,
+		IOPriority:     defaultIOPriority,
+		OOMScoreAdjust: o.OOMScoreAdjust,
+	}
+	if o.IOSchedulingPriority != nil {
+		scheduling.IOPriority = *o.IOSchedulingPriority
+		if scheduling.IOClass == "" {
+			scheduling.IOClass = "best-effort"
+		}
+	}
+	if o.CPUAffinity != "" {
+		cpus, err := sandbox.ParseCPUList(o.CPUAffinity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu_affinity: %v", err)
+		}
+		scheduling.CPUAffinity = cpus
+	}
+	if o.Umask != "" {
+		umask, err := strconv.ParseUint(o.Umask, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid umask: %s, use an octal mode like 0027", o.Umask)
+		}
+		value := int(umask)
+		scheduling.Umask = &value
+	}
+	if err := scheduling.Validate(); err != nil {
+		return nil, err
+	}
+	return scheduling, nil
+}
+
+// Scheduling returns the scheduling values of the running main process by
+// name, nil when it doesn't run
+func (s *Service) Scheduling() map[string]string {
+	if s.Process.Status() != "running" {
+		return nil
+	}
+	pid := s.GetPID()
+	stat, err := procfs.ReadStat(pid)
+	if err != nil {
+		return nil
+	}
+	scheduling, err := procfs.ReadScheduling(pid)
+	if err != nil {
+		return nil
+	}
+
+	values := map[string]string{
+		"nice":         strconv.Itoa(stat.Nice),
+		"cpu_affinity": scheduling.CPUs,
+		"cpu_policy":   sandbox.PolicyName(stat.Policy),
+		"oom_adjust":   strconv.Itoa(scheduling.OOMScoreAdjust),
+	}
+	if stat.RTPriority > 0 {
+		values["cpu_policy"] += " " + strconv.Itoa(stat.RTPriority)
+	}
+	if class, priority, err := sandbox.IOPriority(pid); err == nil {
+		values["io_class"] = class
+		if class == "realtime" || class == "best-effort" {
+			values["io_class"] += " " + strconv.Itoa(priority)
+		}
+	}
+	if scheduling.Umask != "" {
+		values["umask"] = scheduling.Umask
+	}
+	return values
+}
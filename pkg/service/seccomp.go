@@ -0,0 +1,20 @@
+package service
+
+import "ops-ctrl/pkg/sandbox"
+
+// syscallFilter returns the seccomp filter of the main process, nil without
+// one
+func (o Options) syscallFilter() (*sandbox.SyscallFilter, error) {
+	if len(o.SystemCallAllow) == 0 && len(o.SystemCallDeny) == 0 {
+		return nil, nil
+	}
+	filter := &sandbox.SyscallFilter{
+		Allow:  o.SystemCallAllow,
+		Deny:   o.SystemCallDeny,
+		Action: o.SystemCallAction,
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
@@ -4,12 +4,19 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"ops-ctrl/pkg/events"
 )
 
+// EventHandler is told about the state changes of a service
+type EventHandler func(service *Service, kind events.Kind, detail string)
+
 type ServiceStatus struct {
 	State string // Current state. States:
-	// running, stopped, error
+	// initialized, starting, running, stopping, stopped, exited, failed, restarting, error
 	Details []string  // Additional details or logs
 	Updated time.Time // Last update time
 }
@@ -24,53 +31,293 @@ func NewServiceStatus(state string, details ...string) ServiceStatus {
 }
 
 type Service struct {
-	ID      string        // ID of the service
-	Process *Process      // Encapsulated process
-	Status  ServiceStatus // Detailed status of the service
+	ID         string        // ID of the service
+	Type       ServiceType   // Decides when the service counts as started
+	Options    Options       // Options the service was created with
+	Process    *Process      // Encapsulated process
+	Status     ServiceStatus // Detailed status of the service, use GetStatus
+	Restarts   int           // Number of automatic restarts
+	health     string        // healthy, unhealthy or unknown without health checks
+	OnEvent    EventHandler  // Optional, called on state changes
+	notify     *notifySocket // Notification socket of notify and watchdog services
+	generation int           // Incremented on every start and stop, lets supervisors notice they are stale
+	failure    string        // Why the daemon made the current run fail, like a watchdog timeout
+	mu         sync.Mutex    // Guards Status, Restarts, health, generation and failure
+	lifecycle  sync.Mutex    // Serializes starting and stopping
 }
 
 // NewService initializes a new service
-func NewService(id string, command string, args []string, env []string, dir string) (*Service, error) {
+func NewService(id string, opts Options) (*Service, error) {
+	opts, cred, err := opts.resolve()
+	if err != nil {
+		return nil, err
+	}
+	process := NewProcess(opts)
+	process.credential = cred
 	return &Service{
 		ID:      id,
-		Process: NewProcess(command, args, env, dir),
+		Type:    opts.Type,
+		Options: opts,
+		Process: process,
 		Status:  NewServiceStatus("initialized"),
+		health:  "unknown",
 	}, nil
 }
 
 func (s *Service) Start() error {
-	if s.Status.State == "running" {
-		return fmt.Errorf("service is already running")
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	return s.start()
+}
+
+func (s *Service) start() error {
+	if state := s.State(); state == "running" || state == "starting" || state == "stopping" {
+		return fmt.Errorf("service is already %s", state)
+	}
+	s.mu.Lock()
+	s.generation++
+	generation := s.generation
+	s.failure = ""
+	s.mu.Unlock()
+	s.setStatus("starting")
+	s.Process.resetOutput()
+
+	if err := requireMounts(s.Options.RequiresMountsFor); err != nil {
+		s.setStatus("error", err.Error())
+		s.emit(events.Failed, err.Error())
+		return fmt.Errorf("failed to start service: %v", err)
+	}
+	if err := s.runHooks(hookStartPre); err != nil {
+		s.setStatus("error", err.Error())
+		s.emit(events.Failed, err.Error())
+		s.runStopPost()
+		return fmt.Errorf("failed to start service: %v", err)
+	}
+
+	var err error
+	switch s.Type {
+	case TypeForking:
+		err = s.startForking()
+	case TypeNotify:
+		err = s.startNotify()
+	default:
+		// Process.Start waits for the exec of the type exec
+		err = s.spawn()
 	}
-	err := s.Process.Start()
 
 	if err != nil {
-		s.Status = NewServiceStatus("error", fmt.Sprintf("failed to start: %v", err))
+		s.setStatus("error", fmt.Sprintf("failed to start: %v", err))
+		s.emit(events.Failed, fmt.Sprintf("failed to start: %v", err))
+		s.runStopPost()
 		return fmt.Errorf("failed to start service: %v", err)
 	}
 
-	s.Status = NewServiceStatus("running", fmt.Sprintf("started with PID:%d and ID:%s", s.Process.cmd.Process.Pid, s.ID))
-	fmt.Printf("Service started with PID %d and ID %s", s.Process.cmd.Process.Pid, s.ID)
+	// A failed post-start hook stops the service again
+	if err := s.runHooks(hookStartPost); err != nil {
+		s.stop(0)
+		s.setStatus("error", err.Error())
+		s.emit(events.Failed, err.Error())
+		return fmt.Errorf("failed to start service: %v", err)
+	}
+
+	s.setStatus("running", fmt.Sprintf("started with PID:%d and ID:%s", s.GetPID(), s.ID))
+	s.emit(events.Ready, "")
+	fmt.Printf("Service started with PID %d and ID %s", s.GetPID(), s.ID)
+	go s.supervise(generation)
+	if s.Options.WatchdogSec > 0 {
+		go s.watchdog(generation, s.notify)
+	}
+	return nil
+}
+
+// startForking waits for the parent to exit successfully and then supervises
+// the daemon whose PID was written to the PID file
+func (s *Service) startForking() error {
+	os.Remove(s.Options.PIDFile)
+	if err := s.spawn(); err != nil {
+		return err
+	}
+
+	deadline := time.After(s.Options.StartTimeout)
+	select {
+	case <-s.Process.Exited():
+	case <-deadline:
+		s.Process.SignalProcess(os.Kill)
+		return fmt.Errorf("timed out waiting for the parent process to exit")
+	}
+	if code := s.Process.ExitCode(); code != 0 {
+		return fmt.Errorf("parent process exited with code %d", code)
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		pid, err := readPIDFile(s.Options.PIDFile)
+		if err == nil {
+			if !pidAlive(pid) {
+				return fmt.Errorf("process %d from PID file is not running", pid)
+			}
+			s.Process.TrackPID(pid)
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return fmt.Errorf("PID file %s not usable: %v", s.Options.PIDFile, err)
+		}
+	}
+}
+
+func readPIDFile(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID %q", strings.TrimSpace(string(content)))
+	}
+	return pid, nil
+}
+
+// startNotify waits for READY=1 on the notify socket passed by spawn
+func (s *Service) startNotify() error {
+	if err := s.spawn(); err != nil {
+		return err
+	}
+	notify := s.notify
+
+	select {
+	case <-notify.ready:
+	case <-s.Process.Exited():
+		return fmt.Errorf("process exited with code %d before it was ready", s.Process.ExitCode())
+	case <-time.After(s.Options.StartTimeout):
+		s.Process.SignalProcess(os.Kill)
+		return fmt.Errorf("timed out waiting for READY=1")
+	}
+
+	if pid := notify.MainPID(); pid > 0 && pid != s.Process.PID() {
+		s.Process.TrackPID(pid)
+	}
 	return nil
 }
 
+// SignalProcess sends signal to the main PID, the supervisor notices if the
+// process exits because of it
 func (s *Service) SignalProcess(signal os.Signal) error {
 	pid := strconv.Itoa(s.GetPID())
 	err := s.Process.SignalProcess(signal)
 	if err != nil {
-		s.Status = NewServiceStatus("error", fmt.Sprintf("Failed to stop: %v", err))
 		return err
 	}
 
-	s.Status = NewServiceStatus("stopped", "process terminated successfully")
-	fmt.Printf("Service " + pid + " stopped")
+	fmt.Printf("Service " + pid + " received " + signal.String())
+	return nil
+}
+
+// spawn starts the process and reports it. Notify and watchdog services get
+// a new $NOTIFY_SOCKET for every process.
+func (s *Service) spawn() error {
+	if s.Type == TypeNotify || s.Options.WatchdogSec > 0 {
+		if s.notify != nil {
+			s.notify.Close()
+		}
+		notify, err := newNotifySocket(s.ID, s.Process)
+		if err != nil {
+			return err
+		}
+		s.notify = notify
+	}
+
+	if err := s.Process.Start(s.notifyEnvironment()...); err != nil {
+		return err
+	}
+	s.emit(events.Started, "")
 	return nil
 }
 
+// notifyEnvironment returns the variables of the notify socket and the
+// watchdog
+func (s *Service) notifyEnvironment() []string {
+	extraEnv := []string{}
+	if s.notify != nil {
+		extraEnv = append(extraEnv, "NOTIFY_SOCKET="+s.notify.path)
+	}
+	if s.Options.WatchdogSec > 0 {
+		extraEnv = append(extraEnv, fmt.Sprintf("WATCHDOG_USEC=%d", s.Options.WatchdogSec.Microseconds()))
+	}
+	return extraEnv
+}
+
+func (s *Service) emit(kind events.Kind, detail string) {
+	if s.OnEvent != nil {
+		s.OnEvent(s, kind, detail)
+	}
+}
+
+func (s *Service) setStatus(state string, details ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Status = NewServiceStatus(state, details...)
+}
+
+// GetStatus returns a copy of the detailed status
+func (s *Service) GetStatus() ServiceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Status
+}
+
+// Health returns healthy, unhealthy or unknown
+func (s *Service) Health() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.health
+}
+
+// setHealth records the health and reports a change
+func (s *Service) setHealth(health string, detail string) {
+	s.mu.Lock()
+	changed := s.health != health
+	s.health = health
+	s.mu.Unlock()
+
+	if changed {
+		message := health
+		if detail != "" {
+			message += ": " + detail
+		}
+		s.emit(events.Health, message)
+	}
+}
+
+// RestartCount returns the number of automatic restarts
+func (s *Service) RestartCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Restarts
+}
+
+// State returns the current state
+func (s *Service) State() string {
+	return s.GetStatus().State
+}
+
 func (s *Service) CheckStatus() string {
-	return s.Process.Status()
+	status := s.GetStatus()
+	state := status.State
+	if (state == "failed" || state == "restarting") && len(status.Details) > 0 {
+		// Why the last run ended, like a watchdog timeout
+		return state + " (" + status.Details[0] + ")"
+	}
+	if s.notify != nil {
+		if text := s.notify.StatusText(); text != "" {
+			return state + ": " + text
+		}
+	}
+	return state
 }
 
 func (s *Service) GetPID() int {
-	return s.Process.cmd.Process.Pid
+	return s.Process.PID()
 }
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"ops-ctrl/pkg/events"
+	"ops-ctrl/pkg/procfs"
+)
+
+// Snapshot is the state of a service that survives a daemon restart. It
+// holds no environment, values from env_file may be secrets.
+type Snapshot struct {
+	ID         string    `json:"id"`
+	Options    Options   `json:"options"`
+	State      string    `json:"state"`
+	Details    []string  `json:"details,omitempty"`
+	PID        int       `json:"pid,omitempty"`
+	StartTicks uint64    `json:"start_ticks,omitempty"` // Start time of the PID from /proc, tells a reused PID apart
+	StartedAt  time.Time `json:"started_at"`
+	Restarts   int       `json:"restarts"`
+	OutputFD   int       `json:"output_fd,omitempty"` // Output pipe inherited by a re-executed daemon
+}
+
+// Snapshot returns the current state of the service
+func (s *Service) Snapshot() Snapshot {
+	status := s.GetStatus()
+	snapshot := Snapshot{
+		ID:        s.ID,
+		Options:   s.Options,
+		State:     status.State,
+		Details:   status.Details,
+		StartedAt: s.Process.StartedAt(),
+		Restarts:  s.RestartCount(),
+	}
+	if s.Process.Status() == "running" {
+		if stat, err := procfs.ReadStat(s.GetPID()); err == nil {
+			snapshot.PID = stat.PID
+			snapshot.StartTicks = stat.StartTicks
+		}
+	}
+	return snapshot
+}
+
+// RestoreService creates the service of a snapshot and adopts its process if
+// it is still running. lost is true if the process should be running but is
+// gone or its PID now belongs to another process.
+func RestoreService(snapshot Snapshot, onEvent EventHandler) (service *Service, lost bool, err error) {
+	service, err = NewService(snapshot.ID, snapshot.Options)
+	if err != nil {
+		return nil, false, err
+	}
+	service.OnEvent = onEvent
+	service.Restarts = snapshot.Restarts
+	service.Status = NewServiceStatus(snapshot.State, snapshot.Details...)
+
+	switch snapshot.State {
+	case "running", "starting", "stopping", "restarting":
+	default:
+		return service, false, nil
+	}
+
+	var output *os.File
+	if snapshot.OutputFD > 0 {
+		output = os.NewFile(uintptr(snapshot.OutputFD), "output of "+snapshot.ID)
+		syscall.CloseOnExec(snapshot.OutputFD)
+	}
+
+	// A zombie is only adopted if it's our own child, left over from before a
+	// re-exec, so its exit code can still be collected
+	stat, err := procfs.ReadStat(snapshot.PID)
+	zombie := err == nil && stat.State == "Z" && stat.PPID != os.Getpid()
+	if snapshot.PID == 0 || err != nil || stat.StartTicks != snapshot.StartTicks || zombie {
+		if output != nil {
+			output.Close()
+		}
+		service.Status = NewServiceStatus("failed", "process lost while the daemon was not running")
+		return service, true, nil
+	}
+	return service, false, service.adopt(snapshot, output)
+}
+
+// adopt supervises the still running process of a snapshot
+func (s *Service) adopt(snapshot Snapshot, output *os.File) error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	if s.Type == TypeNotify || s.Options.WatchdogSec > 0 {
+		// The process keeps sending to the same path
+		notify, err := newNotifySocket(s.ID, s.Process)
+		if err != nil {
+			return err
+		}
+		notify.readyOnce.Do(func() { close(notify.ready) })
+		s.notify = notify
+	}
+
+	s.mu.Lock()
+	s.generation++
+	generation := s.generation
+	s.mu.Unlock()
+
+	if err := s.Process.Adopt(snapshot.PID, snapshot.StartedAt, output, s.notifyEnvironment()...); err != nil {
+		return err
+	}
+	s.setStatus("running", fmt.Sprintf("adopted PID:%d", snapshot.PID))
+	s.emit(events.Adopted, "")
+	go s.supervise(generation)
+	if s.Options.WatchdogSec > 0 {
+		go s.watchdog(generation, s.notify)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const prSetChildSubreaper = 36
+
+// BecomeSubreaper makes orphaned descendants reparent to the calling process
+// instead of PID 1, which lets forking services be supervised
+func BecomeSubreaper() error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to become subreaper: %v", errno)
+	}
+	return nil
+}
+
+// children counts the claims on PIDs that are waited for by a Process or a
+// hook. Children are started and claimed with the lock held, which
+// ReapZombies holds while it scans, so a child that exits right after it was
+// spawned is never reaped by mistake.
+var children = struct {
+	sync.Mutex
+	claims map[int]int
+}{claims: make(map[int]int)}
+
+// startChild starts cmd and claims its PID, release it after cmd.Wait
+func startChild(cmd *exec.Cmd) error {
+	children.Lock()
+	defer children.Unlock()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	children.claims[cmd.Process.Pid]++
+	return nil
+}
+
+// claimChild keeps ReapZombies away from pid, which is waited for directly
+func claimChild(pid int) {
+	children.Lock()
+	defer children.Unlock()
+	children.claims[pid]++
+}
+
+// releaseChild ends a claim of startChild or claimChild once pid was waited for
+func releaseChild(pid int) {
+	children.Lock()
+	defer children.Unlock()
+	if children.claims[pid]--; children.claims[pid] <= 0 {
+		delete(children.claims, pid)
+	}
+}
+
+// ReapZombies waits for the exited children of this process that are not
+// claimed. Descendants that were reparented to the subreaper end up here.
+func ReapZombies() {
+	children.Lock()
+	defer children.Unlock()
+
+	self := os.Getpid()
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	for _, path := range stats {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// The command name can contain spaces, the fields start after ')'
+		end := strings.LastIndexByte(string(content), ')')
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(string(content[end+1:]))
+		if len(fields) < 2 || fields[0] != "Z" {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if ppid != self || children.claims[pid] > 0 {
+			continue
+		}
+		var status syscall.WaitStatus
+		syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+	}
+}
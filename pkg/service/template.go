@@ -0,0 +1,58 @@
+package service
+
+import (
+	"os"
+	"strings"
+)
+
+// SplitInstance splits "worker@orders" into the template name "worker@" and
+// the instance "orders". ok is false for names that are not instances.
+func SplitInstance(name string) (template string, instance string, ok bool) {
+	prefix, instance, found := strings.Cut(name, "@")
+	if !found || prefix == "" || instance == "" {
+		return "", "", false
+	}
+	return prefix + "@", instance, true
+}
+
+// IsTemplate reports whether name is a template definition like "worker@"
+func IsTemplate(name string) bool {
+	return len(name) > 1 && strings.HasSuffix(name, "@") && strings.Count(name, "@") == 1
+}
+
+// Instantiate returns the options of the instance name of a template with the
+// specifiers replaced. Supported specifiers:
+// %i instance, %n full instance name, %h home directory of the service user,
+// %% a literal %
+func (o Options) Instantiate(name string) Options {
+	_, instance, _ := SplitInstance(name)
+	home, _ := os.UserHomeDir()
+	if o.User != "" {
+		if account, err := lookupUser(o.User); err == nil {
+			home = account.HomeDir
+		}
+	}
+	replacer := strings.NewReplacer("%%", "%", "%i", instance, "%n", name, "%h", home)
+
+	o.Binary = replacer.Replace(o.Binary)
+	o.Args = replaceAll(replacer, o.Args)
+	o.Env = replaceAll(replacer, o.Env)
+	o.EnvFile = replaceAll(replacer, o.EnvFile)
+	o.WorkingDir = replacer.Replace(o.WorkingDir)
+	o.PIDFile = replacer.Replace(o.PIDFile)
+	o.LogPath = replacer.Replace(o.LogPath)
+	o.ExecStartPre = replaceAll(replacer, o.ExecStartPre)
+	o.ExecStartPost = replaceAll(replacer, o.ExecStartPost)
+	o.ExecStop = replaceAll(replacer, o.ExecStop)
+	o.ExecStopPost = replaceAll(replacer, o.ExecStopPost)
+	o.ExecReload = replaceAll(replacer, o.ExecReload)
+	return o
+}
+
+func replaceAll(replacer *strings.Replacer, values []string) []string {
+	replaced := make([]string, len(values))
+	for i, value := range values {
+		replaced[i] = replacer.Replace(value)
+	}
+	return replaced
+}
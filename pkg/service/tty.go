@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"ops-ctrl/pkg/sandbox"
+)
+
+// terminal is the terminal device a service like a getty runs on
+type terminal struct {
+	path    string
+	reset   bool // Reset settings and screen before every start
+	vhangup bool // Hang up earlier sessions before every start
+}
+
+// terminal returns nil for services without tty_path
+func (o Options) terminal() *terminal {
+	if o.TTYPath == "" {
+		return nil
+	}
+	return &terminal{path: o.TTYPath, reset: o.TTYReset, vhangup: o.TTYVHangup}
+}
+
+func (o Options) validateTerminal() error {
+	if o.TTYPath == "" {
+		if o.TTYReset || o.TTYVHangup {
+			return fmt.Errorf("tty_reset and tty_vhangup require a tty_path")
+		}
+		return nil
+	}
+	if !filepath.IsAbs(o.TTYPath) {
+		return fmt.Errorf("tty_path must be an absolute path: %s", o.TTYPath)
+	}
+	// The output goes to the terminal, there is nothing to copy to the log
+	if o.LogPath != "" {
+		return fmt.Errorf("tty_path and log_path can not be combined")
+	}
+	info, err := os.Stat(o.TTYPath)
+	if err != nil {
+		return fmt.Errorf("tty_path %s: %v", o.TTYPath, unwrapPathError(err))
+	}
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("tty_path %s is not a terminal device", o.TTYPath)
+	}
+	return nil
+}
+
+// openTerminal opens the terminal for the next start. A hang up also cuts
+// off the descriptor it was done on, the terminal is opened again after it.
+func (p *Process) openTerminal() (*os.File, error) {
+	tty, err := sandbox.OpenTerminal(p.tty.path)
+	if err != nil {
+		return nil, err
+	}
+	if p.tty.vhangup {
+		err := sandbox.HangupTerminal(tty)
+		tty.Close()
+		if err != nil {
+			return nil, err
+		}
+		if tty, err = sandbox.OpenTerminal(p.tty.path); err != nil {
+			return nil, err
+		}
+	}
+	if p.tty.reset {
+		if err := sandbox.ResetTerminal(tty); err != nil {
+			tty.Close()
+			return nil, err
+		}
+	}
+	return tty, nil
+}
+
+// terminalAttr starts the service in a new session with the terminal on
+// stdin as controlling terminal
+func terminalAttr(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.Setsid = true
+	attr.Setctty = true
+	attr.Ctty = 0
+	return attr
+}
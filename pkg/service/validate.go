@@ -0,0 +1,211 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// credential is the resolved user and group a service runs as
+type credential struct {
+	uid    uint32
+	gid    uint32
+	groups []uint32
+	home   string
+}
+
+// resolve checks that the service can be started and returns the options with
+// the binary resolved to an absolute path. Nothing is started or registered.
+func (o Options) resolve() (Options, *credential, error) {
+	o = o.withDefaults()
+	if err := o.validate(); err != nil {
+		return o, nil, err
+	}
+
+	if _, err := o.filesystem(); err != nil {
+		return o, nil, err
+	}
+
+	// With a root directory the binary and working directory are inside it
+	if o.RootDirectory != "" {
+		if !filepath.IsAbs(o.Binary) {
+			return o, nil, fmt.Errorf("binary %s must be an absolute path inside root_directory", o.Binary)
+		}
+		if err := checkExecutable(filepath.Join(o.RootDirectory, o.Binary)); err != nil {
+			return o, nil, err
+		}
+	} else {
+		binary, err := ResolveBinary(o.Binary)
+		if err != nil {
+			return o, nil, err
+		}
+		o.Binary = binary
+	}
+
+	info, err := os.Stat(filepath.Join(o.RootDirectory, o.WorkingDir))
+	if err != nil {
+		return o, nil, fmt.Errorf("working directory %s: %v", o.WorkingDir, unwrapPathError(err))
+	}
+	if !info.IsDir() {
+		return o, nil, fmt.Errorf("working directory %s is not a directory", o.WorkingDir)
+	}
+
+	if err := o.validateHooks(); err != nil {
+		return o, nil, err
+	}
+	if err := o.validateNamespaces(); err != nil {
+		return o, nil, err
+	}
+	if err := o.validateCapabilities(); err != nil {
+		return o, nil, err
+	}
+	if _, err := o.syscallFilter(); err != nil {
+		return o, nil, err
+	}
+	if _, err := o.landlock(); err != nil {
+		return o, nil, err
+	}
+	if _, err := o.scheduling(); err != nil {
+		return o, nil, err
+	}
+	if err := o.validateTerminal(); err != nil {
+		return o, nil, err
+	}
+
+	cred, err := lookupCredential(o.User, o.Group)
+	if err != nil {
+		return o, nil, err
+	}
+	return o, cred, nil
+}
+
+// ResolveBinary looks up names without a slash in the default PATH of the
+// services and checks that the result is an executable file
+func ResolveBinary(binary string) (string, error) {
+	if binary == "" {
+		return "", fmt.Errorf("program binary undefined")
+	}
+
+	if !strings.Contains(binary, "/") {
+		for _, dir := range filepath.SplitList(defaultPath) {
+			candidate := filepath.Join(dir, binary)
+			if checkExecutable(candidate) == nil {
+				return candidate, nil
+			}
+		}
+		return "", fmt.Errorf("binary %s not found in PATH %s", binary, defaultPath)
+	}
+
+	absolute, err := filepath.Abs(binary)
+	if err != nil {
+		return "", fmt.Errorf("binary %s: %v", binary, err)
+	}
+	if err := checkExecutable(absolute); err != nil {
+		return "", err
+	}
+	return absolute, nil
+}
+
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("binary %s: %v", path, unwrapPathError(err))
+	}
+	if info.IsDir() {
+		return fmt.Errorf("binary %s is a directory", path)
+	}
+	if info.Mode()&0111 == 0 {
+		return fmt.Errorf("binary %s is not executable (mode %s)", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+// lookupCredential resolves user and group names or numeric IDs. It returns
+// nil when neither is set and the service runs as the daemon user. A numeric
+// user ID that isn't in /etc/passwd has no primary group, group must be set
+// for it.
+func lookupCredential(userName string, groupName string) (*credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+
+	cred := &credential{
+		uid:  uint32(os.Getuid()),
+		gid:  uint32(os.Getgid()),
+		home: "/",
+	}
+
+	if userName != "" {
+		account, err := lookupUser(userName)
+		if err != nil {
+			return nil, err
+		}
+		if account.Gid == "" && groupName == "" {
+			return nil, fmt.Errorf("user %s has no passwd entry, its group must be set", userName)
+		}
+		uid, _ := strconv.ParseUint(account.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(account.Gid, 10, 32)
+		cred.uid = uint32(uid)
+		cred.gid = uint32(gid)
+		cred.home = account.HomeDir
+
+		groupIDs, err := account.GroupIds()
+		if err == nil {
+			for _, groupID := range groupIDs {
+				if id, err := strconv.ParseUint(groupID, 10, 32); err == nil {
+					cred.groups = append(cred.groups, uint32(id))
+				}
+			}
+		}
+	}
+
+	if groupName != "" {
+		group, err := lookupGroup(groupName)
+		if err != nil {
+			return nil, err
+		}
+		gid, _ := strconv.ParseUint(group.Gid, 10, 32)
+		cred.gid = uint32(gid)
+	}
+
+	return cred, nil
+}
+
+// lookupUser resolves a user name or numeric ID. A numeric ID doesn't need to
+// exist in /etc/passwd, it's returned without a Gid and with the home "/".
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		account, err := user.LookupId(name)
+		if err != nil {
+			return &user.User{Uid: name, HomeDir: "/"}, nil
+		}
+		return account, nil
+	}
+	account, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user: %s", name)
+	}
+	return account, nil
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return &user.Group{Gid: name}, nil
+	}
+	group, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown group: %s", name)
+	}
+	return group, nil
+}
+
+// unwrapPathError drops the path from errors that already mention it
+func unwrapPathError(err error) error {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// minWatchdogCheck limits how often the watchdog of a short interval checks
+// the deadline and the watchdog file
+const minWatchdogCheck = 100 * time.Millisecond
+
+// watchdog fails the run when the process doesn't ping within watchdog_sec,
+// either with WATCHDOG=1 on the notify socket or by touching watchdog_file.
+// WATCHDOG=trigger fails it right away.
+func (s *Service) watchdog(generation int, notify *notifySocket) {
+	interval := s.Options.WatchdogSec
+	exited := s.Process.Exited()
+	lastPing := time.Now()
+	touched := modTime(s.Options.WatchdogFile)
+
+	check := time.NewTicker(max(interval/4, minWatchdogCheck))
+	defer check.Stop()
+	for {
+		select {
+		case <-exited:
+			return
+		case <-notify.watchdog:
+			lastPing = time.Now()
+			s.setHealth("healthy", "")
+		case <-notify.trigger:
+			s.watchdogFailed(generation, "watchdog triggered")
+			return
+		case <-check.C:
+			if s.isStale(generation) {
+				return
+			}
+			if s.Options.WatchdogFile != "" {
+				if mtime := modTime(s.Options.WatchdogFile); mtime.After(touched) {
+					touched = mtime
+					lastPing = time.Now()
+					s.setHealth("healthy", "")
+				}
+			}
+			if time.Since(lastPing) > interval {
+				s.watchdogFailed(generation, "watchdog timeout")
+				return
+			}
+		}
+	}
+}
+
+// watchdogFailed records reason as failure of the run and sends the watchdog
+// signal, SIGKILL follows if the process is still running after stop_timeout.
+// The supervisor then applies the restart policy.
+func (s *Service) watchdogFailed(generation int, reason string) {
+	s.mu.Lock()
+	if s.generation != generation {
+		s.mu.Unlock()
+		return
+	}
+	s.failure = reason
+	s.mu.Unlock()
+
+	s.setHealth("unhealthy", reason)
+	signal, _ := GetSignal(s.Options.WatchdogSignal)
+	fmt.Printf("Service %s: %s, sending %s\n", s.ID, reason, s.Options.WatchdogSignal)
+
+	exited := s.Process.Exited()
+	if err := s.Process.Signal(signal); err != nil {
+		return
+	}
+	select {
+	case <-exited:
+	case <-time.After(s.Options.StopTimeout):
+		if !s.isStale(generation) {
+			s.Process.Signal(syscall.SIGKILL)
+		}
+	}
+}
+
+// modTime returns the modification time of a file, zero if it's missing
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}